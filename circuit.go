@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreakerIdleTimeout is how long the circuit breaker of an API is kept
+// without any request, so that the breakers of probed targets do not pile up.
+const circuitBreakerIdleTimeout = 10 * time.Minute
+
+// circuitBreakers holds the circuit breaker of every ZLMediaKit API, shared by
+// the exporters of the targets and the probes reaching the same API. Breakers
+// idle for longer than the idle timeout, and at least the cooldown, are evicted.
+type circuitBreakers struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	breakers  map[string]*circuitBreaker
+	lastEvict time.Time
+}
+
+var breakers = newCircuitBreakers(10, 30*time.Second)
+
+func newCircuitBreakers(threshold int, cooldown time.Duration) *circuitBreakers {
+	return &circuitBreakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[string]*circuitBreaker),
+	}
+}
+
+// configure sets the number of consecutive failed requests opening a circuit,
+// 0 disables the circuit breakers, and how long it stays open.
+func (c *circuitBreakers) configure(threshold int, cooldown time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.threshold = threshold
+	c.cooldown = cooldown
+}
+
+// get returns the circuit breaker of an API, to be looked up for every request
+// so that the breaker of an API in use is never evicted.
+func (c *circuitBreakers) get(uri string) *circuitBreaker {
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.evict(now)
+	breaker, ok := c.breakers[uri]
+	if !ok {
+		breaker = &circuitBreaker{parent: c}
+		c.breakers[uri] = breaker
+	}
+	breaker.lastUsed = now
+	return breaker
+}
+
+// evict drops the idle breakers, at most once per idle timeout. The caller must hold c.mutex.
+func (c *circuitBreakers) evict(now time.Time) {
+	if now.Sub(c.lastEvict) < circuitBreakerIdleTimeout {
+		return
+	}
+	c.lastEvict = now
+
+	idleTimeout := max(circuitBreakerIdleTimeout, c.cooldown)
+	for uri, breaker := range c.breakers {
+		if now.Sub(breaker.lastUsed) > idleTimeout {
+			delete(c.breakers, uri)
+		}
+	}
+}
+
+func (c *circuitBreakers) settings() (int, time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.threshold, c.cooldown
+}
+
+// circuitBreaker stops the requests to a ZLMediaKit API once it failed too many
+// times in a row. After the cooldown a single request is let through, closing
+// the circuit on success and opening it again on failure.
+type circuitBreaker struct {
+	parent *circuitBreakers
+	// lastUsed is guarded by the mutex of parent.
+	lastUsed time.Time
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a request may be sent.
+func (b *circuitBreaker) allow() bool {
+	threshold, _ := b.parent.settings()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if threshold <= 0 || b.failures < threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the circuit with the result of an allowed request. A request
+// canceled along with its scrape says nothing about the health of the API,
+// unlike a request running out of time.
+func (b *circuitBreaker) record(err error) {
+	threshold, cooldown := b.parent.settings()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+	switch {
+	case err == nil:
+		b.failures = 0
+	case errors.Is(err, context.Canceled):
+	default:
+		b.failures++
+		if threshold > 0 && b.failures >= threshold {
+			b.openUntil = time.Now().Add(cooldown)
+		}
+	}
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	threshold, _ := b.parent.settings()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return threshold > 0 && b.failures >= threshold
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breakers := newCircuitBreakers(2, 50*time.Millisecond)
+	breaker := breakers.get("http://127.0.0.1")
+	assert.Same(t, breaker, breakers.get("http://127.0.0.1"))
+
+	errRequest := errors.New("connection refused")
+
+	assert.True(t, breaker.allow())
+	breaker.record(errRequest)
+	assert.False(t, breaker.isOpen())
+
+	breaker.record(context.Canceled)
+	assert.False(t, breaker.isOpen(), "canceled requests must not count")
+
+	breaker.record(errRequest)
+	assert.True(t, breaker.isOpen())
+	assert.False(t, breaker.allow())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, breaker.allow(), "a request must be let through after the cooldown")
+	assert.False(t, breaker.allow(), "only one request is let through after the cooldown")
+	breaker.record(errRequest)
+	assert.False(t, breaker.allow())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, breaker.allow())
+	breaker.record(nil)
+	assert.False(t, breaker.isOpen())
+	assert.True(t, breaker.allow())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breakers := newCircuitBreakers(0, time.Minute)
+	breaker := breakers.get("http://127.0.0.1")
+	for i := 0; i < 10; i++ {
+		breaker.record(errors.New("connection refused"))
+	}
+	assert.False(t, breaker.isOpen())
+	assert.True(t, breaker.allow())
+}
+
+func TestCircuitBreakersEvict(t *testing.T) {
+	breakers := newCircuitBreakers(2, time.Hour)
+	idle := breakers.get("http://10.0.0.1")
+	used := breakers.get("http://10.0.0.2")
+	idle.lastUsed = time.Now().Add(-2 * circuitBreakerIdleTimeout)
+
+	breakers.lastEvict = time.Time{}
+	breakers.get("http://10.0.0.3")
+	assert.Contains(t, breakers.breakers, "http://10.0.0.1", "breakers are kept for at least the cooldown")
+
+	breakers.cooldown = time.Minute
+	breakers.lastEvict = time.Time{}
+	breakers.get("http://10.0.0.3")
+	assert.NotContains(t, breakers.breakers, "http://10.0.0.1", "idle breakers must be evicted")
+	assert.Same(t, used, breakers.get("http://10.0.0.2"), "breakers in use must be kept")
+	assert.NotSame(t, idle, breakers.get("http://10.0.0.1"))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), retryBackoff(0, 3))
+	for attempt := 0; attempt < 4; attempt++ {
+		wait := retryBackoff(100*time.Millisecond, attempt)
+		maxWait := 100 * time.Millisecond << attempt
+		assert.GreaterOrEqual(t, wait, maxWait/2)
+		assert.LessOrEqual(t, wait, maxWait)
+	}
+
+	// the wait is capped instead of overflowing on a large number of retries
+	for _, attempt := range []int{20, 37, 64, 1000} {
+		wait := retryBackoff(100*time.Millisecond, attempt)
+		assert.GreaterOrEqual(t, wait, maxRetryBackoff/2)
+		assert.LessOrEqual(t, wait, maxRetryBackoff)
+	}
+	assert.LessOrEqual(t, retryBackoff(24*time.Hour, 64), maxRetryBackoff)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	promconfig "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultModuleName = "default"
+
+// Config is the content of the file passed with --config.file.
+type Config struct {
+	Modules map[string]Module `yaml:"modules"`
+	Targets []Target          `yaml:"targets"`
+
+	// clients holds the HTTP client of every probed module, shared by its
+	// probes so that they reuse their connections.
+	clientsMutex sync.Mutex
+	clients      map[string]http.Client
+}
+
+// Module holds the settings used to reach a ZLMediaKit API,
+// either probed through /probe or listed as a target.
+// Retries is a pointer so that an explicit 0 disables the retries.
+// AllowedTargets lists the targets a module probes, and so the hosts its secret is sent to;
+// "*" allows any target.
+type Module struct {
+	Secret         string               `yaml:"secret"`
+	SecretFile     string               `yaml:"secret_file"`
+	Timeout        time.Duration        `yaml:"timeout"`
+	Retries        *int                 `yaml:"retries"`
+	RetryBackoff   time.Duration        `yaml:"retry_backoff"`
+	TLSConfig      promconfig.TLSConfig `yaml:"tls_config"`
+	Collectors     []string             `yaml:"collectors"`
+	AllowedTargets []string             `yaml:"allowed_targets"`
+}
+
+// Target is a ZLMediaKit instance scraped on every request to the metrics path.
+// Labels are attached to every metric exported for the target.
+type Target struct {
+	Name   string            `yaml:"name"`
+	URL    string            `yaml:"url"`
+	Labels map[string]string `yaml:"labels"`
+	Module `yaml:",inline"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	for name, module := range config.Modules {
+		if err := module.validate(); err != nil {
+			return nil, fmt.Errorf("module %s: %w", name, err)
+		}
+		if len(module.AllowedTargets) == 0 {
+			return nil, fmt.Errorf("module %s: allowed_targets is required, use ['*'] to allow any target", name)
+		}
+		config.Modules[name] = module
+	}
+
+	if err := config.validateTargets(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) validateTargets() error {
+	names := make(map[string]bool)
+	labelSets := make(map[string]string)
+	var labelNames []string
+
+	for i := range c.Targets {
+		target := &c.Targets[i]
+		if target.Name == "" {
+			return fmt.Errorf("target #%d: name is required", i+1)
+		}
+		if names[target.Name] {
+			return fmt.Errorf("target %s: duplicate name", target.Name)
+		}
+		names[target.Name] = true
+
+		uri, err := parseProbeTarget(target.URL)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target.Name, err)
+		}
+		target.URL = uri
+
+		if err := target.Module.validate(); err != nil {
+			return fmt.Errorf("target %s: %w", target.Name, err)
+		}
+		if len(target.AllowedTargets) > 0 {
+			return fmt.Errorf("target %s: allowed_targets only applies to modules", target.Name)
+		}
+
+		labelSet := model.LabelSet{}
+		for name, value := range target.Labels {
+			if !model.LabelName(name).IsValidLegacy() {
+				return fmt.Errorf("target %s: invalid label name %q", target.Name, name)
+			}
+			if metricLabels[name] {
+				return fmt.Errorf("target %s: label name %q is already used by the exported metrics", target.Name, name)
+			}
+			labelSet[model.LabelName(name)] = model.LabelValue(value)
+		}
+		// The metrics of every target are registered together, which requires the
+		// same label names on every target.
+		targetLabelNames := make([]string, 0, len(target.Labels))
+		for name := range target.Labels {
+			targetLabelNames = append(targetLabelNames, name)
+		}
+		slices.Sort(targetLabelNames)
+		if i == 0 {
+			labelNames = targetLabelNames
+		} else if !slices.Equal(labelNames, targetLabelNames) {
+			return fmt.Errorf("targets %s and %s have different label names", c.Targets[0].Name, target.Name)
+		}
+		// Targets are exported side by side, so they need distinct labels to tell their series apart.
+		if other, ok := labelSets[labelSet.String()]; ok {
+			return fmt.Errorf("targets %s and %s have identical labels", other, target.Name)
+		}
+		labelSets[labelSet.String()] = target.Name
+	}
+	return nil
+}
+
+// client returns the HTTP client of a module, created on its first probe.
+func (c *Config) client(name string, module Module) (http.Client, error) {
+	c.clientsMutex.Lock()
+	defer c.clientsMutex.Unlock()
+
+	if client, ok := c.clients[name]; ok {
+		return client, nil
+	}
+	client, err := newHTTPClient(module.options())
+	if err != nil {
+		return http.Client{}, err
+	}
+	if c.clients == nil {
+		c.clients = make(map[string]http.Client)
+	}
+	c.clients[name] = client
+	return client, nil
+}
+
+// closeIdleConnections closes the idle connections of the module clients,
+// once a reload replaced the config.
+func (c *Config) closeIdleConnections() {
+	c.clientsMutex.Lock()
+	defer c.clientsMutex.Unlock()
+
+	for _, client := range c.clients {
+		client.CloseIdleConnections()
+	}
+}
+
+// setDefaultModule registers the module built from command line flags,
+// unless the config file already defines one with the same name.
+// The secret given on the command line belongs to the --zlm.api-url instance,
+// so the module only probes target.
+func (c *Config) setDefaultModule(module Module, target string) {
+	if c.Modules == nil {
+		c.Modules = make(map[string]Module)
+	}
+	if _, ok := c.Modules[DefaultModuleName]; ok || module.Secret == "" {
+		return
+	}
+	module.AllowedTargets = nil
+	if uri, err := parseProbeTarget(target); err == nil {
+		module.AllowedTargets = []string{uri}
+	}
+	c.Modules[DefaultModuleName] = module
+}
+
+// setDefaults applies the timeout and retries given by command line flags
+// to the modules and targets that do not set them.
+func (c *Config) setDefaults(defaults Module) {
+	for name, module := range c.Modules {
+		module.setDefaults(defaults)
+		c.Modules[name] = module
+	}
+	for i := range c.Targets {
+		c.Targets[i].setDefaults(defaults)
+	}
+}
+
+func (m *Module) setDefaults(defaults Module) {
+	if m.Timeout == 0 {
+		m.Timeout = defaults.Timeout
+	}
+	if m.Retries == nil {
+		m.Retries = defaults.Retries
+	}
+	if m.RetryBackoff == 0 {
+		m.RetryBackoff = defaults.RetryBackoff
+	}
+}
+
+func (m *Module) validate() error {
+	if err := m.resolveSecret(); err != nil {
+		return err
+	}
+	if m.Retries != nil && *m.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	if err := m.TLSConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid tls_config: %w", err)
+	}
+	for i, target := range m.AllowedTargets {
+		if target == "*" {
+			continue
+		}
+		uri, err := parseProbeTarget(target)
+		if err != nil {
+			return fmt.Errorf("invalid allowed_targets: %w", err)
+		}
+		m.AllowedTargets[i] = uri
+	}
+	return validateCollectors(m.Collectors)
+}
+
+// allowsTarget reports whether the module may probe target.
+func (m *Module) allowsTarget(target string) bool {
+	return slices.Contains(m.AllowedTargets, "*") || slices.Contains(m.AllowedTargets, target)
+}
+
+func (m *Module) resolveSecret() error {
+	if m.Secret != "" && m.SecretFile != "" {
+		return fmt.Errorf("secret and secret_file are mutually exclusive")
+	}
+
+	if m.SecretFile != "" {
+		secret, err := os.ReadFile(m.SecretFile)
+		if err != nil {
+			return fmt.Errorf("error reading secret file: %w", err)
+		}
+		m.Secret = strings.TrimSpace(string(secret))
+	}
+
+	if m.Secret == "" {
+		return fmt.Errorf("secret is required")
+	}
+	return nil
+}
+
+func (m Module) options() Options {
+	options := Options{
+		TLSConfig:    m.TLSConfig,
+		Timeout:      m.Timeout,
+		RetryBackoff: m.RetryBackoff,
+		Collectors:   m.Collectors,
+	}
+	if m.Retries != nil {
+		options.Retries = *m.Retries
+	}
+	return options
+}
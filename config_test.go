@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	promconfig "github.com/prometheus/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(file, []byte(content), 0o600)
+	assert.NoError(t, err)
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	secretFile := writeTestFile(t, "secret", "file-secret\n")
+
+	tests := []struct {
+		name          string
+		content       string
+		expectedError bool
+		expected      map[string]Module
+	}{
+		{
+			name: "inline secret",
+			content: `
+modules:
+  edge:
+    secret: inline-secret
+    allowed_targets: ['*']
+    tls_config:
+      insecure_skip_verify: true
+      server_name: zlm.example.com
+      min_version: TLS12
+`,
+			expected: map[string]Module{
+				"edge": {Secret: "inline-secret", AllowedTargets: []string{"*"}, TLSConfig: promconfig.TLSConfig{
+					InsecureSkipVerify: true,
+					ServerName:         "zlm.example.com",
+					MinVersion:         tls.VersionTLS12,
+				}},
+			},
+		},
+		{
+			name: "secret file",
+			content: `
+modules:
+  edge:
+    secret_file: ` + secretFile + `
+    allowed_targets: [10.0.0.1:80]
+`,
+			expected: map[string]Module{
+				"edge": {Secret: "file-secret", SecretFile: secretFile, AllowedTargets: []string{"http://10.0.0.1:80"}},
+			},
+		},
+		{
+			name: "missing secret",
+			content: `
+modules:
+  edge: {}
+`,
+			expectedError: true,
+		},
+		{
+			name: "secret and secret file",
+			content: `
+modules:
+  edge:
+    secret: inline-secret
+    secret_file: ` + secretFile + `
+`,
+			expectedError: true,
+		},
+		{
+			name: "allowed targets",
+			content: `
+modules:
+  edge:
+    secret: inline-secret
+    allowed_targets: [10.0.0.1:80, https://10.0.0.2/]
+`,
+			expected: map[string]Module{
+				"edge": {Secret: "inline-secret", AllowedTargets: []string{"http://10.0.0.1:80", "https://10.0.0.2"}},
+			},
+		},
+		{
+			name: "missing allowed targets",
+			content: `
+modules:
+  edge:
+    secret: inline-secret
+`,
+			expectedError: true,
+		},
+		{
+			name: "invalid allowed target",
+			content: `
+modules:
+  edge:
+    secret: inline-secret
+    allowed_targets: [rtsp://10.0.0.1]
+`,
+			expectedError: true,
+		},
+		{
+			name: "unknown field",
+			content: `
+modules:
+  edge:
+    secret: inline-secret
+    allowed_targets: ['*']
+    unknown: true
+`,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadConfig(writeTestFile(t, "config.yml", tt.content))
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, config.Modules)
+		})
+	}
+}
+
+func TestLoadConfigTargets(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		expectedError bool
+		expected      []Target
+	}{
+		{
+			name: "targets",
+			content: `
+targets:
+  - name: edge-1
+    url: 10.0.0.1:80
+    secret: secret-1
+    timeout: 5s
+    collectors: [version, stream]
+    labels:
+      region: eu
+  - name: edge-2
+    url: https://10.0.0.2/
+    secret: secret-2
+    labels:
+      region: us
+`,
+			expected: []Target{
+				{
+					Name:   "edge-1",
+					URL:    "http://10.0.0.1:80",
+					Labels: map[string]string{"region": "eu"},
+					Module: Module{Secret: "secret-1", Timeout: 5 * time.Second, Collectors: []string{"version", "stream"}},
+				},
+				{
+					Name:   "edge-2",
+					URL:    "https://10.0.0.2",
+					Labels: map[string]string{"region": "us"},
+					Module: Module{Secret: "secret-2"},
+				},
+			},
+		},
+		{
+			name: "missing name",
+			content: `
+targets:
+  - url: 10.0.0.1:80
+    secret: secret-1
+`,
+			expectedError: true,
+		},
+		{
+			name: "duplicate name",
+			content: `
+targets:
+  - name: edge
+    url: 10.0.0.1:80
+    secret: secret-1
+    labels: {region: eu}
+  - name: edge
+    url: 10.0.0.2:80
+    secret: secret-2
+    labels: {region: us}
+`,
+			expectedError: true,
+		},
+		{
+			name: "invalid url",
+			content: `
+targets:
+  - name: edge
+    url: rtsp://10.0.0.1
+    secret: secret-1
+`,
+			expectedError: true,
+		},
+		{
+			name: "unknown collector",
+			content: `
+targets:
+  - name: edge
+    url: 10.0.0.1:80
+    secret: secret-1
+    collectors: [unknown]
+`,
+			expectedError: true,
+		},
+		{
+			name: "invalid label name",
+			content: `
+targets:
+  - name: edge
+    url: 10.0.0.1:80
+    secret: secret-1
+    labels: {"invalid-label": eu}
+`,
+			expectedError: true,
+		},
+		{
+			name: "allowed targets on a target",
+			content: `
+targets:
+  - name: edge
+    url: 10.0.0.1:80
+    secret: secret-1
+    allowed_targets: [10.0.0.1:80]
+`,
+			expectedError: true,
+		},
+		{
+			name: "label name of the exported metrics",
+			content: `
+targets:
+  - name: edge
+    url: 10.0.0.1:80
+    secret: secret-1
+    labels: {app: x}
+`,
+			expectedError: true,
+		},
+		{
+			name: "different label names",
+			content: `
+targets:
+  - name: edge-1
+    url: 10.0.0.1:80
+    secret: secret-1
+    labels: {region: eu}
+  - name: edge-2
+    url: 10.0.0.2:80
+    secret: secret-2
+    labels: {cluster: c1}
+`,
+			expectedError: true,
+		},
+		{
+			name: "identical labels",
+			content: `
+targets:
+  - name: edge-1
+    url: 10.0.0.1:80
+    secret: secret-1
+  - name: edge-2
+    url: 10.0.0.2:80
+    secret: secret-2
+`,
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadConfig(writeTestFile(t, "config.yml", tt.content))
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, config.Targets)
+		})
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestSetDefaultModule(t *testing.T) {
+	config := &Config{}
+	config.setDefaultModule(Module{}, "http://127.0.0.1")
+	assert.NotContains(t, config.Modules, DefaultModuleName)
+
+	config.setDefaultModule(Module{Secret: "flag-secret"}, "127.0.0.1:80")
+	assert.Equal(t, "flag-secret", config.Modules[DefaultModuleName].Secret)
+	assert.Equal(t, []string{"http://127.0.0.1:80"}, config.Modules[DefaultModuleName].AllowedTargets,
+		"the flag secret must only be sent to --zlm.api-url")
+
+	config.setDefaultModule(Module{Secret: "other-secret"}, "http://127.0.0.1")
+	assert.Equal(t, "flag-secret", config.Modules[DefaultModuleName].Secret)
+}
+
+func TestSetDefaults(t *testing.T) {
+	config := &Config{
+		Modules: map[string]Module{
+			"edge":   {Secret: "secret"},
+			"origin": {Secret: "secret", Timeout: time.Second, Retries: intPtr(1)},
+		},
+		Targets: []Target{
+			{Name: "edge", Module: Module{Secret: "secret"}},
+			{Name: "origin", Module: Module{Secret: "secret", Timeout: time.Second, Retries: intPtr(0), RetryBackoff: time.Second}},
+		},
+	}
+	config.setDefaults(Module{Timeout: 5 * time.Second, Retries: intPtr(2), RetryBackoff: 100 * time.Millisecond})
+
+	assert.Equal(t, Module{Secret: "secret", Timeout: 5 * time.Second, Retries: intPtr(2), RetryBackoff: 100 * time.Millisecond}, config.Modules["edge"])
+	assert.Equal(t, Module{Secret: "secret", Timeout: time.Second, Retries: intPtr(1), RetryBackoff: 100 * time.Millisecond}, config.Modules["origin"])
+	assert.Equal(t, Module{Secret: "secret", Timeout: 5 * time.Second, Retries: intPtr(2), RetryBackoff: 100 * time.Millisecond}, config.Targets[0].Module)
+	assert.Equal(t, Module{Secret: "secret", Timeout: time.Second, Retries: intPtr(0), RetryBackoff: time.Second}, config.Targets[1].Module,
+		"an explicit retries: 0 must disable the retries")
+	assert.Equal(t, 0, config.Targets[1].options().Retries)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hookMaxBodySize caps the body of the web hooks, ZLMediaKit sends small JSON objects.
+const hookMaxBodySize = 1 << 20
+
+// defaultAliveInterval is the default hook.alive_interval of ZLMediaKit, assumed
+// for the servers whose on_server_started was not received.
+const defaultAliveInterval = 10 * time.Second
+
+// hookEvents lists the web hooks of ZLMediaKit, hook.on_* in getServerConfig.
+// The event is taken from the last element of the path of the hook, so that
+// every hook.on_* setting can point to <hook-path>/<event>.
+var hookEvents = map[string]bool{
+	"on_flow_report":        true,
+	"on_http_access":        true,
+	"on_play":               true,
+	"on_publish":            true,
+	"on_record_mp4":         true,
+	"on_record_ts":          true,
+	"on_rtp_server_timeout": true,
+	"on_rtsp_auth":          true,
+	"on_rtsp_realm":         true,
+	"on_send_rtp_stopped":   true,
+	"on_server_exited":      true,
+	"on_server_keepalive":   true,
+	"on_server_started":     true,
+	"on_shell_login":        true,
+	"on_stream_changed":     true,
+	"on_stream_none_reader": true,
+	"on_stream_not_found":   true,
+}
+
+// hookEvent holds the fields shared by the web hooks of ZLMediaKit.
+type hookEvent struct {
+	App string `json:"app"`
+}
+
+// hookOptions configures the hook receiver.
+type hookOptions struct {
+	// ClientsWindow is the window within which the clients of on_publish and
+	// on_play are counted as unique clients.
+	ClientsWindow time.Duration
+	// Upstream is the URL the hooks are forwarded to, followed by the path of
+	// the hook below the hook path. Empty answers the hooks locally.
+	Upstream        string
+	UpstreamTimeout time.Duration
+	// KeepaliveMultiplier is the number of hook.alive_interval without any
+	// on_server_keepalive after which a server is reported stale.
+	KeepaliveMultiplier float64
+}
+
+// hookReceiver turns the web hooks posted by ZLMediaKit into metrics. Without an
+// upstream it always answers {"code":0}, which lets ZLMediaKit go on with every
+// publish, play and other request it asks about. With an upstream, the answer
+// of the upstream is relayed unchanged, so the upstream keeps the decisions.
+type hookReceiver struct {
+	logger   *slog.Logger
+	upstream string
+	client   http.Client
+
+	events       *prometheus.CounterVec
+	flowBytes    *prometheus.CounterVec
+	flowDuration *prometheus.HistogramVec
+	attempts     *prometheus.CounterVec
+
+	clients       *uniqueClients
+	uniqueClients *prometheus.Desc
+
+	upstreamDuration *prometheus.HistogramVec
+	upstreamErrors   *prometheus.CounterVec
+
+	servers       *mediaServers
+	restarts      *prometheus.CounterVec
+	lastKeepalive *prometheus.Desc
+	stale         *prometheus.Desc
+}
+
+func newHookReceiver(logger *slog.Logger, options hookOptions) *hookReceiver {
+	return &hookReceiver{
+		logger:   logger,
+		upstream: strings.TrimSuffix(options.Upstream, "/"),
+		client:   http.Client{Timeout: options.UpstreamTimeout},
+
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "hook_events_total",
+			Help:      "Number of web hooks received from ZLMediaKit.",
+		}, []string{"event", "app"}),
+
+		flowBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "flow_bytes_total",
+			Help:      "Bytes of the ended sessions reported by on_flow_report, in for publishers and out for players.",
+		}, []string{"app", "stream", "direction"}),
+
+		flowDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "flow_session_duration_seconds",
+			Help:      "Duration of the ended sessions reported by on_flow_report.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 9),
+		}, []string{"app", "direction"}),
+
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "hook_attempts_total",
+			Help:      "Number of publish and play attempts reported by on_publish and on_play by the answer of the upstream, unknown when answered by the exporter.",
+		}, []string{"action", "app", "schema", "result"}),
+
+		clients: newUniqueClients(options.ClientsWindow),
+		uniqueClients: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "hook", "unique_clients"),
+			"Number of distinct client addresses which tried to publish or play within the clients window.",
+			[]string{"action", "app"}, nil),
+
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "hook_upstream_duration_seconds",
+			Help:      "Duration of the requests forwarding the web hooks to the upstream.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"event"}),
+
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "hook_upstream_errors_total",
+			Help:      "Number of web hooks the upstream failed to answer or answered with an error status.",
+		}, []string{"event"}),
+
+		servers: newMediaServers(options.KeepaliveMultiplier),
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "server_restarts_total",
+			Help:      "Number of restarts of ZLMediaKit reported by on_server_started, the first start seen by the exporter is not counted.",
+		}, []string{"media_server_id"}),
+		lastKeepalive: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "server", "last_keepalive_timestamp_seconds"),
+			"Unix timestamp of the last on_server_keepalive received from ZLMediaKit.",
+			[]string{"media_server_id"}, nil),
+		stale: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "server", "keepalive_stale"),
+			"Whether ZLMediaKit exited or sent no on_server_keepalive for hook.alive_interval times the keepalive multiplier.",
+			[]string{"media_server_id"}, nil),
+	}
+}
+
+func (h *hookReceiver) Describe(ch chan<- *prometheus.Desc) {
+	h.events.Describe(ch)
+	h.flowBytes.Describe(ch)
+	h.flowDuration.Describe(ch)
+	h.attempts.Describe(ch)
+	ch <- h.uniqueClients
+	h.upstreamDuration.Describe(ch)
+	h.upstreamErrors.Describe(ch)
+	h.restarts.Describe(ch)
+	ch <- h.lastKeepalive
+	ch <- h.stale
+}
+
+func (h *hookReceiver) Collect(ch chan<- prometheus.Metric) {
+	h.events.Collect(ch)
+	h.flowBytes.Collect(ch)
+	h.flowDuration.Collect(ch)
+	h.attempts.Collect(ch)
+	for key, count := range h.clients.count(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(h.uniqueClients, prometheus.GaugeValue, float64(count), key.action, key.app)
+	}
+	h.upstreamDuration.Collect(ch)
+	h.upstreamErrors.Collect(ch)
+	h.restarts.Collect(ch)
+	for id, server := range h.servers.status(time.Now()) {
+		if !server.lastKeepalive.IsZero() {
+			ch <- prometheus.MustNewConstMetric(h.lastKeepalive, prometheus.GaugeValue, float64(server.lastKeepalive.UnixNano())/1e9, id)
+		}
+		ch <- prometheus.MustNewConstMetric(h.stale, prometheus.GaugeValue, boolToFloat64(server.stale), id)
+	}
+}
+
+func (h *hookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := path.Base(r.URL.Path)
+	if !hookEvents[event] {
+		h.logger.Debug("unknown web hook", "path", r.URL.Path)
+		event = "unknown"
+	}
+
+	var hook hookEvent
+	body, readErr := io.ReadAll(http.MaxBytesReader(w, r.Body, hookMaxBodySize))
+	err := readErr
+	if err == nil {
+		err = json.Unmarshal(body, &hook)
+	}
+	if err != nil {
+		h.logger.Debug("error decoding web hook", "event", event, "err", err)
+	}
+	h.events.WithLabelValues(event, hook.App).Inc()
+
+	if err == nil {
+		switch event {
+		case "on_flow_report":
+			h.flowReport(body)
+		case "on_server_started", "on_server_keepalive", "on_server_exited":
+			h.server(event, body)
+		}
+	}
+
+	// The attempts are recorded once answered, along with the result of the authorization.
+	result := hookResultUnknown
+	if h.upstream != "" {
+		if readErr != nil {
+			writeHookError(w, http.StatusBadRequest, "error reading web hook")
+			return
+		}
+		result = h.forward(w, r, event, body)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}
+
+	if err == nil {
+		switch event {
+		case "on_publish":
+			h.attempt("publish", body, result)
+		case "on_play":
+			h.attempt("play", body, result)
+		}
+	}
+}
+
+// The results of the authorization of the hooks. ZLMediaKit only goes on when the
+// hook is answered with a 200 status and a zero code, anything else denies the request.
+const (
+	hookResultAllowed = "allowed"
+	hookResultDenied  = "denied"
+	hookResultUnknown = "unknown"
+)
+
+// hookAnswer holds the code of the answer to a web hook.
+type hookAnswer struct {
+	Code *int `json:"code"`
+}
+
+// hookResult returns the result of the authorization ZLMediaKit reads from an answer.
+func hookResult(status int, body []byte) string {
+	var answer hookAnswer
+	if status != http.StatusOK || json.Unmarshal(body, &answer) != nil || answer.Code == nil || *answer.Code != 0 {
+		return hookResultDenied
+	}
+	return hookResultAllowed
+}
+
+// forward relays a web hook to the upstream and its answer back to ZLMediaKit, and
+// returns the result of the authorization in the answer. An unreachable upstream is
+// answered with an error, as ZLMediaKit would get without the exporter in between.
+func (h *hookReceiver) forward(w http.ResponseWriter, r *http.Request, event string, body []byte) string {
+	uri := h.upstream + r.URL.Path
+	if r.URL.RawQuery != "" {
+		uri += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, uri, bytes.NewReader(body))
+	if err != nil {
+		h.upstreamErrors.WithLabelValues(event).Inc()
+		h.logger.Error("error creating web hook request", "event", event, "err", err)
+		writeHookError(w, http.StatusBadGateway, "error forwarding web hook")
+		return hookResultDenied
+	}
+	req.Header = forwardedHeader(r.Header)
+
+	start := time.Now()
+	res, err := h.client.Do(req)
+	h.upstreamDuration.WithLabelValues(event).Observe(time.Since(start).Seconds())
+	if err != nil {
+		h.upstreamErrors.WithLabelValues(event).Inc()
+		h.logger.Error("error forwarding web hook", "event", event, "err", err)
+		writeHookError(w, http.StatusBadGateway, "error forwarding web hook")
+		return hookResultDenied
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		h.upstreamErrors.WithLabelValues(event).Inc()
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(res.StatusCode)
+	// The answers are small JSON objects, anything beyond hookMaxBodySize is relayed without being decoded.
+	answer, err := io.ReadAll(io.LimitReader(res.Body, hookMaxBodySize))
+	if err == nil {
+		_, err = w.Write(answer)
+	}
+	if err == nil {
+		_, err = io.Copy(w, res.Body)
+	}
+	if err != nil {
+		h.logger.Error("error relaying web hook answer", "event", event, "err", err)
+		return hookResultDenied
+	}
+	return hookResult(res.StatusCode, answer)
+}
+
+// hopHeaders lists the hop-by-hop headers, which concern the connection from
+// ZLMediaKit and are not forwarded to the upstream.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// forwardedHeader returns the headers of a hook forwarded to the upstream. The hop-by-hop
+// headers are dropped, and so is Accept-Encoding, which lets the client decompress the
+// answer of the upstream so that ZLMediaKit gets it as the upstream meant it.
+func forwardedHeader(header http.Header) http.Header {
+	forwarded := header.Clone()
+	for _, value := range forwarded.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			forwarded.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		forwarded.Del(name)
+	}
+	forwarded.Del("Accept-Encoding")
+	return forwarded
+}
+
+// validateHookUpstream checks the upstream URL of the hooks, empty disables forwarding.
+func validateHookUpstream(upstream string) error {
+	if upstream == "" {
+		return nil
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", upstream)
+	}
+	return nil
+}
+
+func writeHookError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"code": -1, "msg": msg})
+}
+
+// flowReport is the body of on_flow_report, posted when a player or publisher disconnects.
+type flowReport struct {
+	App        string  `json:"app"`
+	Stream     string  `json:"stream"`
+	Player     bool    `json:"player"`
+	TotalBytes float64 `json:"totalBytes"`
+	Duration   float64 `json:"duration"`
+}
+
+func (h *hookReceiver) flowReport(body []byte) {
+	var report flowReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		h.logger.Debug("error decoding on_flow_report", "err", err)
+		return
+	}
+	if report.TotalBytes < 0 || report.Duration < 0 {
+		h.logger.Debug("invalid on_flow_report", "total_bytes", report.TotalBytes, "duration", report.Duration)
+		return
+	}
+	direction := "in"
+	if report.Player {
+		direction = "out"
+	}
+	h.flowBytes.WithLabelValues(report.App, report.Stream, direction).Add(report.TotalBytes)
+	h.flowDuration.WithLabelValues(report.App, direction).Observe(report.Duration)
+}
+
+// mediaAccess is the body of on_publish and on_play. The params, which often
+// carry the tokens checked by the authentication, are left out of the metrics.
+type mediaAccess struct {
+	Schema string `json:"schema"`
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+	IP     string `json:"ip"`
+}
+
+// attempt records an attempt along with the result of its authorization, which is
+// unknown when the exporter answers the hook itself.
+func (h *hookReceiver) attempt(action string, body []byte, result string) {
+	var access mediaAccess
+	if err := json.Unmarshal(body, &access); err != nil {
+		h.logger.Debug("error decoding web hook", "action", action, "err", err)
+		return
+	}
+	h.attempts.WithLabelValues(action, access.App, access.Schema, result).Inc()
+	if access.IP != "" {
+		h.clients.seen(uniqueClientsKey{action: action, app: access.App}, access.IP, time.Now())
+	}
+}
+
+type uniqueClientsKey struct {
+	action, app string
+}
+
+// uniqueClients remembers when every client address was last seen, addresses
+// older than the window are forgotten.
+type uniqueClients struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	clients map[uniqueClientsKey]map[string]time.Time
+}
+
+func newUniqueClients(window time.Duration) *uniqueClients {
+	return &uniqueClients{
+		window:  window,
+		clients: make(map[uniqueClientsKey]map[string]time.Time),
+	}
+}
+
+func (u *uniqueClients) seen(key uniqueClientsKey, ip string, now time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.clients[key] == nil {
+		u.clients[key] = make(map[string]time.Time)
+	}
+	u.clients[key][ip] = now
+}
+
+// count returns the number of clients seen within the window, keys without any
+// client left are dropped so that their series go away.
+func (u *uniqueClients) count(now time.Time) map[uniqueClientsKey]int {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	counts := make(map[uniqueClientsKey]int, len(u.clients))
+	for key, clients := range u.clients {
+		for ip, lastSeen := range clients {
+			if now.Sub(lastSeen) > u.window {
+				delete(clients, ip)
+			}
+		}
+		if len(clients) == 0 {
+			delete(u.clients, key)
+			continue
+		}
+		counts[key] = len(clients)
+	}
+	return counts
+}
+
+// serverHook is the body of on_server_started, on_server_keepalive and
+// on_server_exited. on_server_started posts the whole configuration, whose
+// values are strings.
+type serverHook struct {
+	MediaServerID       string `json:"mediaServerId"`
+	ConfigMediaServerID string `json:"general.mediaServerId"`
+	AliveInterval       any    `json:"hook.alive_interval"`
+}
+
+func (h *hookReceiver) server(event string, body []byte) {
+	var hook serverHook
+	if err := json.Unmarshal(body, &hook); err != nil {
+		h.logger.Debug("error decoding web hook", "event", event, "err", err)
+		return
+	}
+	id := cmp.Or(hook.MediaServerID, hook.ConfigMediaServerID)
+	now := time.Now()
+	// The counter is exported from the first hook of a server, so that its first restart is an increase.
+	restarts := h.restarts.WithLabelValues(id)
+
+	switch event {
+	case "on_server_started":
+		aliveInterval := defaultAliveInterval
+		if seconds, ok := hookFloat(hook.AliveInterval); ok && seconds > 0 {
+			aliveInterval = time.Duration(seconds * float64(time.Second))
+		}
+		if h.servers.started(id, aliveInterval, now) {
+			restarts.Inc()
+		}
+	case "on_server_keepalive":
+		h.servers.keepalive(id, now)
+	case "on_server_exited":
+		h.servers.exited(id)
+	}
+}
+
+// hookFloat reads a number which ZLMediaKit posts either as a JSON number or
+// as a string.
+func hookFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+type mediaServerState struct {
+	aliveInterval time.Duration
+	lastSeen      time.Time
+	lastKeepalive time.Time
+	exited        bool
+}
+
+type mediaServerStatus struct {
+	lastKeepalive time.Time
+	stale         bool
+}
+
+// mediaServers follows the servers posting on_server_* hooks. A server is
+// stale once it exited, or when neither on_server_started nor
+// on_server_keepalive was received for its alive interval times the multiplier.
+type mediaServers struct {
+	mutex      sync.Mutex
+	multiplier float64
+	servers    map[string]*mediaServerState
+}
+
+func newMediaServers(multiplier float64) *mediaServers {
+	return &mediaServers{
+		multiplier: multiplier,
+		servers:    make(map[string]*mediaServerState),
+	}
+}
+
+// server returns the state of a server, created with the default alive
+// interval when the server was not seen yet.
+func (m *mediaServers) server(id string) *mediaServerState {
+	server, ok := m.servers[id]
+	if !ok {
+		server = &mediaServerState{aliveInterval: defaultAliveInterval}
+		m.servers[id] = server
+	}
+	return server
+}
+
+// started records a start of a server and reports whether it is a restart, that
+// is whether the server was seen before.
+func (m *mediaServers) started(id string, aliveInterval time.Duration, now time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, seen := m.servers[id]
+	server := m.server(id)
+	server.aliveInterval = aliveInterval
+	server.lastSeen = now
+	server.exited = false
+	return seen
+}
+
+func (m *mediaServers) keepalive(id string, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	server := m.server(id)
+	server.lastSeen = now
+	server.lastKeepalive = now
+	server.exited = false
+}
+
+func (m *mediaServers) exited(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.server(id).exited = true
+}
+
+func (m *mediaServers) status(now time.Time) map[string]mediaServerStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status := make(map[string]mediaServerStatus, len(m.servers))
+	for id, server := range m.servers {
+		timeout := time.Duration(float64(server.aliveInterval) * m.multiplier)
+		status[id] = mediaServerStatus{
+			lastKeepalive: server.lastKeepalive,
+			stale:         server.exited || now.Sub(server.lastSeen) > timeout,
+		}
+	}
+	return status
+}
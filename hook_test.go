@@ -0,0 +1,309 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func postHook(t *testing.T, handler http.Handler, target, body string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"code":0}`, rec.Body.String())
+}
+
+func TestHookReceiver(t *testing.T) {
+	hooks := newHookReceiver(promslog.New(&promslog.Config{}), hookOptions{ClientsWindow: time.Minute})
+
+	postHook(t, hooks, "/hook/on_publish", `{"app":"live","stream":"test","schema":"rtmp","mediaServerId":"your_server_id"}`)
+	postHook(t, hooks, "/hook/on_publish", `{"app":"live","stream":"test2","schema":"rtsp"}`)
+	postHook(t, hooks, "/hook/on_play", `{"app":"live","stream":"test"}`)
+	postHook(t, hooks, "/hook/on_server_keepalive", `{"data":{},"mediaServerId":"your_server_id"}`)
+	postHook(t, hooks, "/hook/on_unknown", `{"app":"live"}`)
+	postHook(t, hooks, "/hook/on_play", `not json`)
+
+	expected := `
+# HELP zlm_hook_events_total Number of web hooks received from ZLMediaKit.
+# TYPE zlm_hook_events_total counter
+zlm_hook_events_total{app="",event="on_play"} 1
+zlm_hook_events_total{app="",event="on_server_keepalive"} 1
+zlm_hook_events_total{app="live",event="on_play"} 1
+zlm_hook_events_total{app="live",event="on_publish"} 2
+zlm_hook_events_total{app="live",event="unknown"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(hooks, strings.NewReader(expected), "zlm_hook_events_total"))
+}
+
+func TestHookReceiverBodyTooLarge(t *testing.T) {
+	hooks := newHookReceiver(promslog.New(&promslog.Config{}), hookOptions{ClientsWindow: time.Minute})
+
+	postHook(t, hooks, "/hook/on_flow_report", `{"app":"`+strings.Repeat("a", hookMaxBodySize)+`"}`)
+	assert.Equal(t, 1.0, testutil.ToFloat64(hooks.events.WithLabelValues("on_flow_report", "")))
+}
+
+func TestHookReceiverFlowReport(t *testing.T) {
+	hooks := newHookReceiver(promslog.New(&promslog.Config{}), hookOptions{ClientsWindow: time.Minute})
+
+	postHook(t, hooks, "/hook/on_flow_report", `{"app":"live","stream":"test","player":false,"totalBytes":1000000,"duration":100,"schema":"rtmp","ip":"10.0.0.1"}`)
+	postHook(t, hooks, "/hook/on_flow_report", `{"app":"live","stream":"test","player":true,"totalBytes":40000,"duration":3,"schema":"rtsp","ip":"10.0.0.2"}`)
+	postHook(t, hooks, "/hook/on_flow_report", `{"app":"live","stream":"test","player":true,"totalBytes":60000,"duration":20,"schema":"flv","ip":"10.0.0.3"}`)
+	postHook(t, hooks, "/hook/on_flow_report", `{"app":"live","stream":"test","player":true,"totalBytes":-1,"duration":1}`)
+	postHook(t, hooks, "/hook/on_play", `{"app":"live","stream":"test","player":true,"totalBytes":1}`)
+
+	expected := `
+# HELP zlm_flow_bytes_total Bytes of the ended sessions reported by on_flow_report, in for publishers and out for players.
+# TYPE zlm_flow_bytes_total counter
+zlm_flow_bytes_total{app="live",direction="in",stream="test"} 1e+06
+zlm_flow_bytes_total{app="live",direction="out",stream="test"} 100000
+# HELP zlm_flow_session_duration_seconds Duration of the ended sessions reported by on_flow_report.
+# TYPE zlm_flow_session_duration_seconds histogram
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="1"} 0
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="4"} 0
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="16"} 0
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="64"} 0
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="256"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="1024"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="4096"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="16384"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="65536"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="in",le="+Inf"} 1
+zlm_flow_session_duration_seconds_sum{app="live",direction="in"} 100
+zlm_flow_session_duration_seconds_count{app="live",direction="in"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="1"} 0
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="4"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="16"} 1
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="64"} 2
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="256"} 2
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="1024"} 2
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="4096"} 2
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="16384"} 2
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="65536"} 2
+zlm_flow_session_duration_seconds_bucket{app="live",direction="out",le="+Inf"} 2
+zlm_flow_session_duration_seconds_sum{app="live",direction="out"} 23
+zlm_flow_session_duration_seconds_count{app="live",direction="out"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(hooks, strings.NewReader(expected), "zlm_flow_bytes_total", "zlm_flow_session_duration_seconds"))
+}
+
+func TestHookReceiverAttempts(t *testing.T) {
+	hooks := newHookReceiver(promslog.New(&promslog.Config{}), hookOptions{ClientsWindow: time.Minute})
+
+	postHook(t, hooks, "/hook/on_publish", `{"schema":"rtmp","app":"live","stream":"cam1","ip":"10.0.0.1","params":"token=secret"}`)
+	postHook(t, hooks, "/hook/on_publish", `{"schema":"rtsp","app":"live","stream":"cam2","ip":"10.0.0.1","params":""}`)
+	postHook(t, hooks, "/hook/on_play", `{"schema":"rtmp","app":"live","stream":"cam1","ip":"10.0.0.2"}`)
+	postHook(t, hooks, "/hook/on_play", `{"schema":"hls","app":"live","stream":"cam1","ip":"10.0.0.3"}`)
+	postHook(t, hooks, "/hook/on_play", `{"schema":"hls","app":"live","stream":"cam1","ip":"10.0.0.3"}`)
+
+	expected := `
+# HELP zlm_hook_attempts_total Number of publish and play attempts reported by on_publish and on_play by the answer of the upstream, unknown when answered by the exporter.
+# TYPE zlm_hook_attempts_total counter
+zlm_hook_attempts_total{action="play",app="live",result="unknown",schema="hls"} 2
+zlm_hook_attempts_total{action="play",app="live",result="unknown",schema="rtmp"} 1
+zlm_hook_attempts_total{action="publish",app="live",result="unknown",schema="rtmp"} 1
+zlm_hook_attempts_total{action="publish",app="live",result="unknown",schema="rtsp"} 1
+# HELP zlm_hook_unique_clients Number of distinct client addresses which tried to publish or play within the clients window.
+# TYPE zlm_hook_unique_clients gauge
+zlm_hook_unique_clients{action="play",app="live"} 2
+zlm_hook_unique_clients{action="publish",app="live"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(hooks, strings.NewReader(expected), "zlm_hook_attempts_total", "zlm_hook_unique_clients"))
+}
+
+func TestUniqueClients(t *testing.T) {
+	clients := newUniqueClients(time.Minute)
+	now := time.Now()
+	play := uniqueClientsKey{action: "play", app: "live"}
+
+	clients.seen(play, "10.0.0.1", now)
+	clients.seen(play, "10.0.0.2", now.Add(30*time.Second))
+	clients.seen(play, "10.0.0.1", now.Add(40*time.Second))
+	assert.Equal(t, map[uniqueClientsKey]int{play: 2}, clients.count(now.Add(time.Minute)))
+	assert.Equal(t, map[uniqueClientsKey]int{play: 1}, clients.count(now.Add(95*time.Second)))
+	assert.Empty(t, clients.count(now.Add(2*time.Minute)))
+	assert.Empty(t, clients.clients, "keys without clients must be dropped")
+}
+
+func TestHookReceiverUpstream(t *testing.T) {
+	var paths, bodies []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		paths = append(paths, r.URL.RequestURI())
+		bodies = append(bodies, string(body))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if path.Base(r.URL.Path) == "on_play" {
+			http.Error(w, `{"code":-1,"msg":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		if strings.Contains(string(body), "token=good") {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"code":0,"msg":"success"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`{"code":401,"msg":"invalid token","enable_hls":true}`))
+	}))
+	defer upstream.Close()
+
+	hooks := newHookReceiver(promslog.New(&promslog.Config{}), hookOptions{ClientsWindow: time.Minute, Upstream: upstream.URL + "/index/hook/", UpstreamTimeout: time.Second})
+	forward := func(target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		hooks.ServeHTTP(rec, req)
+		return rec
+	}
+
+	publish := `{"schema":"rtmp","app":"live","stream":"cam1","ip":"10.0.0.1","params":"token=bad"}`
+	rec := forward("/on_publish?id=1", publish)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `{"code":401,"msg":"invalid token","enable_hls":true}`, rec.Body.String(), "the answer of the upstream must be relayed unchanged")
+
+	rec = forward("/on_play", `{"schema":"hls","app":"live","stream":"cam1","ip":"10.0.0.2"}`)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	assert.Equal(t, []string{"/index/hook/on_publish?id=1", "/index/hook/on_play"}, paths)
+	assert.Equal(t, publish, bodies[0])
+	assert.Equal(t, 1.0, testutil.ToFloat64(hooks.attempts.WithLabelValues("publish", "live", "rtmp", "denied")), "forwarded hooks must be recorded")
+	assert.Equal(t, 1.0, testutil.ToFloat64(hooks.attempts.WithLabelValues("play", "live", "hls", "denied")), "an error status denies the play")
+	assert.Equal(t, 0.0, testutil.ToFloat64(hooks.upstreamErrors.WithLabelValues("on_publish")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(hooks.upstreamErrors.WithLabelValues("on_play")))
+	assert.Equal(t, 2, testutil.CollectAndCount(hooks, "zlm_hook_upstream_duration_seconds"))
+
+	rec = forward("/on_publish", `{"schema":"rtmp","app":"live","stream":"cam1","ip":"10.0.0.1","params":"token=good"}`)
+	assert.Equal(t, `{"code":0,"msg":"success"}`, rec.Body.String())
+	assert.Equal(t, 1.0, testutil.ToFloat64(hooks.attempts.WithLabelValues("publish", "live", "rtmp", "allowed")))
+
+	upstream.Close()
+	rec = forward("/on_publish", publish)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.JSONEq(t, `{"code":-1,"msg":"error forwarding web hook"}`, rec.Body.String())
+	assert.Equal(t, 1.0, testutil.ToFloat64(hooks.upstreamErrors.WithLabelValues("on_publish")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(hooks.attempts.WithLabelValues("publish", "live", "rtmp", "denied")), "an unreachable upstream denies the publish")
+}
+
+func TestHookReceiverUpstreamHeaders(t *testing.T) {
+	answer := `{"code":0,"msg":"success"}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("X-Hop"), "the headers named by Connection must not be forwarded")
+		assert.Empty(t, r.Header.Get("Keep-Alive"))
+		assert.Equal(t, "1", r.Header.Get("X-End"))
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			_, _ = w.Write([]byte(answer))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write([]byte(answer))
+		_ = gz.Close()
+	}))
+	defer upstream.Close()
+
+	hooks := newHookReceiver(promslog.New(&promslog.Config{}), hookOptions{ClientsWindow: time.Minute, Upstream: upstream.URL, UpstreamTimeout: time.Second})
+	req := httptest.NewRequest(http.MethodPost, "/on_publish", strings.NewReader(`{"schema":"rtmp","app":"live","stream":"cam1"}`))
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	req.Header.Set("Connection", "keep-alive, X-Hop")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("X-End", "1")
+	rec := httptest.NewRecorder()
+	hooks.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, answer, rec.Body.String(), "a compressed answer must be relayed decompressed")
+	assert.Equal(t, 1.0, testutil.ToFloat64(hooks.attempts.WithLabelValues("publish", "live", "rtmp", "allowed")))
+}
+
+func TestHookResult(t *testing.T) {
+	assert.Equal(t, hookResultAllowed, hookResult(http.StatusOK, []byte(`{"code":0,"enable_hls":true}`)))
+	assert.Equal(t, hookResultDenied, hookResult(http.StatusOK, []byte(`{"code":-1,"msg":"forbidden"}`)))
+	assert.Equal(t, hookResultDenied, hookResult(http.StatusOK, []byte(`{"msg":"no code"}`)))
+	assert.Equal(t, hookResultDenied, hookResult(http.StatusOK, []byte(`not json`)))
+	assert.Equal(t, hookResultDenied, hookResult(http.StatusForbidden, []byte(`{"code":0}`)))
+}
+
+func TestValidateHookUpstream(t *testing.T) {
+	assert.NoError(t, validateHookUpstream(""))
+	assert.NoError(t, validateHookUpstream("http://10.0.0.1:8080/index/hook"))
+	assert.NoError(t, validateHookUpstream("https://hooks.example.com"))
+	assert.Error(t, validateHookUpstream("10.0.0.1:8080"))
+	assert.Error(t, validateHookUpstream("ftp://10.0.0.1"))
+	assert.Error(t, validateHookUpstream("http://"))
+}
+
+func TestHookReceiverServer(t *testing.T) {
+	hooks := newHookReceiver(promslog.New(&promslog.Config{}), hookOptions{ClientsWindow: time.Minute, KeepaliveMultiplier: 3})
+
+	postHook(t, hooks, "/hook/on_server_started", `{"general.mediaServerId":"zlm-1","hook.alive_interval":"10.0","mediaServerId":"zlm-1"}`)
+	postHook(t, hooks, "/hook/on_server_keepalive", `{"data":{"Buffer":12},"mediaServerId":"zlm-1"}`)
+	postHook(t, hooks, "/hook/on_server_started", `{"general.mediaServerId":"zlm-1","hook.alive_interval":"10.0"}`)
+	postHook(t, hooks, "/hook/on_server_started", `{"general.mediaServerId":"zlm-2","mediaServerId":"zlm-2"}`)
+	postHook(t, hooks, "/hook/on_server_exited", `{"mediaServerId":"zlm-2"}`)
+
+	expected := `
+# HELP zlm_server_keepalive_stale Whether ZLMediaKit exited or sent no on_server_keepalive for hook.alive_interval times the keepalive multiplier.
+# TYPE zlm_server_keepalive_stale gauge
+zlm_server_keepalive_stale{media_server_id="zlm-1"} 0
+zlm_server_keepalive_stale{media_server_id="zlm-2"} 1
+# HELP zlm_server_restarts_total Number of restarts of ZLMediaKit reported by on_server_started, the first start seen by the exporter is not counted.
+# TYPE zlm_server_restarts_total counter
+zlm_server_restarts_total{media_server_id="zlm-1"} 1
+zlm_server_restarts_total{media_server_id="zlm-2"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(hooks, strings.NewReader(expected), "zlm_server_keepalive_stale", "zlm_server_restarts_total"))
+	assert.Equal(t, 1, testutil.CollectAndCount(hooks, "zlm_server_last_keepalive_timestamp_seconds"), "servers without keepalive have no timestamp")
+}
+
+func TestMediaServers(t *testing.T) {
+	servers := newMediaServers(3)
+	now := time.Now()
+
+	assert.False(t, servers.started("zlm", 5*time.Second, now), "the first start is not a restart")
+	assert.False(t, servers.status(now.Add(15 * time.Second))["zlm"].stale)
+	assert.True(t, servers.status(now.Add(16 * time.Second))["zlm"].stale, "no keepalive within 3 alive intervals")
+
+	servers.keepalive("zlm", now.Add(20*time.Second))
+	status := servers.status(now.Add(30 * time.Second))
+	assert.False(t, status["zlm"].stale)
+	assert.Equal(t, now.Add(20*time.Second), status["zlm"].lastKeepalive)
+
+	servers.exited("zlm")
+	assert.True(t, servers.started("zlm", 5*time.Second, now.Add(20*time.Second)))
+	servers.exited("zlm")
+	assert.True(t, servers.status(now.Add(20 * time.Second))["zlm"].stale, "an exited server is stale at once")
+
+	servers.keepalive("other", now)
+	assert.True(t, servers.status(now.Add(31 * time.Second))["other"].stale, "the default alive interval applies without on_server_started")
+}
+
+func TestHookFloat(t *testing.T) {
+	tests := []struct {
+		value any
+		want  float64
+		ok    bool
+	}{
+		{"10.0", 10, true},
+		{30.0, 30, true},
+		{"", 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := hookFloat(tt.value)
+		assert.Equal(t, tt.ok, ok, "%v", tt.value)
+		assert.Equal(t, tt.want, got, "%v", tt.value)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeHandler scrapes the ZLMediaKit instance given by the target parameter,
+// using the secret and TLS settings of the requested module. Targets outside
+// the allowed targets of the module are refused, so that its secret is not sent to them.
+// doc: https://prometheus.io/docs/guides/multi-target-exporter/
+func probeHandler(w http.ResponseWriter, r *http.Request, c *Config, logger *slog.Logger, timeout time.Duration, timeoutOffset time.Duration) {
+	params := r.URL.Query()
+
+	target, err := parseProbeTarget(params.Get("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = DefaultModuleName
+	}
+	module, ok := c.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	if !module.allowsTarget(target) {
+		http.Error(w, fmt.Sprintf("Target %q is not allowed by module %q", target, moduleName), http.StatusForbidden)
+		return
+	}
+
+	if err := validateAPI(target, module.Secret); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The probes of a module share its client, a client per probe would leave its connections open.
+	client, err := c.client(moduleName, module)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exporter := newExporter(logger.With("target", target, "module", moduleName))
+	exporter.setConfig(&exporterConfig{
+		scrapeURI:    target,
+		scrapeSecret: module.Secret,
+		client:       client,
+		options:      module.options(),
+	})
+	exporter.probe = true
+
+	ctx, cancel := scrapeContext(r, timeoutOffset)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter.withContext(ctx))
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		Timeout: timeout,
+	}).ServeHTTP(w, r)
+}
+
+func parseProbeTarget(target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("target parameter is missing")
+	}
+
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid target %q: unsupported scheme %q", target, u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid target %q: missing host", target)
+	}
+
+	return strings.TrimRight(target, "/"), nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/promslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeHandler(t *testing.T) {
+	server := setupTestDataServer(t)
+	defer server.Close()
+
+	config := &Config{
+		Modules: map[string]Module{
+			DefaultModuleName: {Secret: MockZlmAPIServerSecret, AllowedTargets: []string{server.URL}},
+			"any":             {Secret: MockZlmAPIServerSecret, AllowedTargets: []string{"*"}},
+			"none":            {Secret: MockZlmAPIServerSecret},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		query          url.Values
+		expectedStatus int
+		expectedBody   []string
+	}{
+		{
+			name:           "missing target",
+			query:          url.Values{},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown module",
+			query:          url.Values{"target": {server.URL}, "module": {"unknown"}},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "default module",
+			query:          url.Values{"target": {server.URL}},
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				`zlm_version_info{branchName="master",buildTime="2024-06-11T21:28:30",commitHash="c446f6b"} 1`,
+				"zlm_network_threads_total 8",
+				"zlm_up 1",
+			},
+		},
+		{
+			name:           "target without scheme",
+			query:          url.Values{"target": {strings.TrimPrefix(server.URL, "http://")}, "module": {DefaultModuleName}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{"zlm_network_threads_total 8"},
+		},
+		{
+			name:           "allowed target",
+			query:          url.Values{"target": {server.URL + "/"}, "module": {DefaultModuleName}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{"zlm_up 1"},
+		},
+		{
+			name:           "target not allowed",
+			query:          url.Values{"target": {"10.0.0.1:80"}},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "any target allowed",
+			query:          url.Values{"target": {server.URL}, "module": {"any"}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{"zlm_up 1"},
+		},
+		{
+			name:           "no allowed targets",
+			query:          url.Values{"target": {server.URL}, "module": {"none"}},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/probe?"+tt.query.Encode(), nil)
+			rec := httptest.NewRecorder()
+
+			probeHandler(rec, req, config, promslog.New(&promslog.Config{}), 10*time.Second, 500*time.Millisecond)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			body, _ := io.ReadAll(rec.Body)
+			for _, expected := range tt.expectedBody {
+				assert.Contains(t, string(body), expected)
+			}
+		})
+	}
+}
+
+func TestProbeHandlerCounters(t *testing.T) {
+	apiList := []string{"/index/api/getApiList", "/" + ZlmAPIEndpointListFFmpegSource, "/" + ZlmAPIEndpointGetServerConfig}
+	apiServer := setupAPIListServer(t, &apiList)
+	defer apiServer.Close()
+	// A failing collector makes the exporter count a scrape error.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) == "getServerConfig" {
+			_, _ = w.Write([]byte("invalid json"))
+			return
+		}
+		apiServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Modules: map[string]Module{
+			DefaultModuleName: {Secret: MockZlmAPIServerSecret, Collectors: []string{SubsystemStream, SubsystemFFmpegSource, SubsystemConfig}, AllowedTargets: []string{"*"}},
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/probe?"+url.Values{"target": {server.URL}}.Encode(), nil)
+	rec := httptest.NewRecorder()
+	probeHandler(rec, req, config, promslog.New(&promslog.Config{}), 10*time.Second, 500*time.Millisecond)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, "zlm_stream_info")
+	assert.Contains(t, body, "zlm_ffmpeg_source_up")
+	assert.Contains(t, body, `zlm_scrape_collector_success{collector="config"} 0`)
+	assert.NotContains(t, body, "zlm_stream_bytes_total", "counters accumulated across scrapes start over on every probe")
+	assert.NotContains(t, body, "zlm_ffmpeg_source_restarts_total", "counters accumulated across scrapes start over on every probe")
+	assert.NotContains(t, body, "zlm_exporter_scrapes_total", "counters accumulated across scrapes start over on every probe")
+	assert.NotContains(t, body, "zlm_scrape_errors_total", "counters accumulated across scrapes start over on every probe")
+}
+
+func TestProbeHandlerReusesConnections(t *testing.T) {
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(testDataHandler(t))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	config := &Config{
+		Modules: map[string]Module{
+			DefaultModuleName: {Secret: MockZlmAPIServerSecret, Collectors: []string{SubsystemVersion}, AllowedTargets: []string{"*"}},
+		},
+	}
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/probe?"+url.Values{"target": {server.URL}}.Encode(), nil)
+		rec := httptest.NewRecorder()
+		probeHandler(rec, req, config, promslog.New(&promslog.Config{}), 10*time.Second, 500*time.Millisecond)
+		assert.Contains(t, rec.Body.String(), "zlm_up 1")
+	}
+	assert.Equal(t, int32(1), connections.Load(), "the probes of a module must share its connections")
+	config.closeIdleConnections()
+}
+
+func TestParseProbeTarget(t *testing.T) {
+	tests := []struct {
+		name          string
+		target        string
+		expected      string
+		expectedError bool
+	}{
+		{name: "empty", target: "", expectedError: true},
+		{name: "host and port", target: "10.0.0.1:8080", expected: "http://10.0.0.1:8080"},
+		{name: "https", target: "https://zlm.example.com/", expected: "https://zlm.example.com"},
+		{name: "unsupported scheme", target: "rtsp://10.0.0.1", expectedError: true},
+		{name: "missing host", target: "http://", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := parseProbeTarget(tt.target)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, target)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reloader loads the config file and applies it to the probe modules and the
+// served targets, at startup and then on SIGHUP or POST /-/reload.
+type reloader struct {
+	configFile    string
+	defaultURL    string
+	defaultModule Module
+
+	// reloadMutex serializes the reloads triggered by SIGHUP and POST /-/reload.
+	reloadMutex sync.Mutex
+	mutex       sync.RWMutex
+	config      *Config
+	targets     *targetsHandler
+	logger      *slog.Logger
+
+	lastReloadSuccessful       prometheus.Gauge
+	lastReloadSuccessTimestamp prometheus.Gauge
+}
+
+func newReloader(configFile string, defaultURL string, defaultModule Module, targets *targetsHandler, logger *slog.Logger) *reloader {
+	return &reloader{
+		configFile:    configFile,
+		defaultURL:    defaultURL,
+		defaultModule: defaultModule,
+		config:        &Config{},
+		targets:       targets,
+		logger:        logger,
+
+		lastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "exporter_config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+
+		lastReloadSuccessTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "exporter_config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
+	}
+}
+
+func (r *reloader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.lastReloadSuccessful.Desc()
+	ch <- r.lastReloadSuccessTimestamp.Desc()
+}
+
+func (r *reloader) Collect(ch chan<- prometheus.Metric) {
+	ch <- r.lastReloadSuccessful
+	ch <- r.lastReloadSuccessTimestamp
+}
+
+func (r *reloader) currentConfig() *Config {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.config
+}
+
+func (r *reloader) reload() error {
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+
+	if err := r.apply(); err != nil {
+		r.lastReloadSuccessful.Set(0)
+		return err
+	}
+	r.lastReloadSuccessful.Set(1)
+	r.lastReloadSuccessTimestamp.SetToCurrentTime()
+	return nil
+}
+
+func (r *reloader) apply() error {
+	config := &Config{}
+	if r.configFile != "" {
+		var err error
+		if config, err = LoadConfig(r.configFile); err != nil {
+			return err
+		}
+	}
+	config.setDefaultModule(r.defaultModule, r.defaultURL)
+	config.setDefaults(r.defaultModule)
+
+	targets := config.Targets
+	// Without a secret the exporter can still serve probes for the modules of the config file.
+	if len(targets) == 0 && (r.defaultModule.Secret != "" || r.configFile == "") {
+		targets = []Target{{
+			Name:   DefaultModuleName,
+			URL:    r.defaultURL,
+			Module: config.Modules[DefaultModuleName],
+		}}
+	}
+	if len(targets) == 0 {
+		r.logger.Info("no ZLMediaKit target configured, only serving probes")
+	}
+
+	if err := r.targets.update(targets); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	previous := r.config
+	r.config = config
+	r.mutex.Unlock()
+
+	previous.closeIdleConnections()
+	return nil
+}
+
+// ServeHTTP handles POST /-/reload.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		r.logger.Error("error reloading config", "error", err)
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		return
+	}
+	r.logger.Info("config reloaded")
+}
+
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := r.reload(); err != nil {
+			r.logger.Error("error reloading config", "error", err)
+			continue
+		}
+		r.logger.Info("config reloaded")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloader(t *testing.T) {
+	server := setupTestDataServer(t)
+	defer server.Close()
+
+	configFile := writeTestFile(t, "config.yml", `
+modules:
+  edge:
+    secret: module-secret
+    allowed_targets: ['*']
+targets:
+  - name: edge-1
+    url: `+server.URL+`
+    secret: `+MockZlmAPIServerSecret+`
+    collectors: [version]
+`)
+
+	logger := promslog.New(&promslog.Config{})
+	targets := newTargetsHandler(prometheus.NewRegistry(), promhttp.HandlerOpts{}, 0, 0, logger)
+	reloader := newReloader(configFile, "http://127.0.0.1", Module{Secret: "flag-secret"}, targets, logger)
+
+	assert.NoError(t, reloader.reload())
+	assert.Equal(t, float64(1), testutil.ToFloat64(reloader.lastReloadSuccessful))
+	assert.Equal(t, "module-secret", reloader.currentConfig().Modules["edge"].Secret)
+	assert.Equal(t, "flag-secret", reloader.currentConfig().Modules[DefaultModuleName].Secret)
+	assert.Len(t, targets.targets, 1)
+	exporter := targets.targets[0].exporter
+
+	err := os.WriteFile(configFile, []byte(`
+modules:
+  edge:
+    secret: rotated-secret
+    allowed_targets: ['*']
+targets:
+  - name: edge-1
+    url: `+server.URL+`
+    secret: `+MockZlmAPIServerSecret+`
+    collectors: [version, rtp]
+`), 0o600)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	reloader.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "rotated-secret", reloader.currentConfig().Modules["edge"].Secret)
+	assert.Same(t, exporter, targets.targets[0].exporter)
+	assert.Equal(t, []string{SubsystemVersion, SubsystemRtp}, exporter.config.Load().options.Collectors)
+
+	err = os.WriteFile(configFile, []byte("targets: [invalid"), 0o600)
+	assert.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	reloader.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, float64(0), testutil.ToFloat64(reloader.lastReloadSuccessful))
+	assert.Equal(t, "rotated-secret", reloader.currentConfig().Modules["edge"].Secret)
+
+	rec = httptest.NewRecorder()
+	reloader.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestReloaderWithoutConfigFile(t *testing.T) {
+	logger := promslog.New(&promslog.Config{})
+
+	targets := newTargetsHandler(prometheus.NewRegistry(), promhttp.HandlerOpts{}, 0, 0, logger)
+	reloader := newReloader("", "http://127.0.0.1", Module{Secret: "flag-secret"}, targets, logger)
+	assert.NoError(t, reloader.reload())
+	assert.Len(t, targets.targets, 1)
+	assert.Equal(t, "http://127.0.0.1", targets.targets[0].exporter.config.Load().scrapeURI)
+	defaultModule := reloader.currentConfig().Modules[DefaultModuleName]
+	assert.True(t, defaultModule.allowsTarget("http://127.0.0.1"))
+	assert.False(t, defaultModule.allowsTarget("http://10.0.0.1"))
+
+	// the flag target requires a secret
+	targets = newTargetsHandler(prometheus.NewRegistry(), promhttp.HandlerOpts{}, 0, 0, logger)
+	reloader = newReloader("", "http://127.0.0.1", Module{}, targets, logger)
+	assert.Error(t, reloader.reload())
+}
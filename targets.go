@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeTarget is an exporter together with the constant labels of its target.
+type scrapeTarget struct {
+	name     string
+	labels   prometheus.Labels
+	exporter *Exporter
+}
+
+// newScrapeTargets builds the exporters of targets, reusing the exporter of
+// a previous target with the same name so that its counters are kept.
+// Exporters poll ZLMediaKit in the background when pollInterval is positive.
+func newScrapeTargets(targets []Target, previous []scrapeTarget, pollInterval time.Duration, logger *slog.Logger) ([]scrapeTarget, error) {
+	exporters := make(map[string]*Exporter, len(previous))
+	for _, target := range previous {
+		exporters[target.name] = target.exporter
+	}
+
+	scrapeTargets := make([]scrapeTarget, 0, len(targets))
+	for _, target := range targets {
+		options := target.options()
+		options.PollInterval = pollInterval
+
+		exporter, ok := exporters[target.Name]
+		if ok {
+			if err := exporter.Update(target.URL, target.Secret, options); err != nil {
+				return nil, err
+			}
+		} else {
+			var err error
+			exporter, err = NewExporter(target.URL, target.Secret, logger.With("target", target.Name), options)
+			if err != nil {
+				return nil, err
+			}
+		}
+		scrapeTargets = append(scrapeTargets, scrapeTarget{
+			name:     target.Name,
+			labels:   target.Labels,
+			exporter: exporter,
+		})
+	}
+	return scrapeTargets, nil
+}
+
+// targetsHandler serves the metrics of every target, wrapping each exporter
+// with the labels of its target, along with the metrics of the base gatherer.
+type targetsHandler struct {
+	mutex         sync.RWMutex
+	targets       []scrapeTarget
+	base          prometheus.Gatherer
+	opts          promhttp.HandlerOpts
+	timeoutOffset time.Duration
+	pollInterval  time.Duration
+	logger        *slog.Logger
+}
+
+func newTargetsHandler(base prometheus.Gatherer, opts promhttp.HandlerOpts, timeoutOffset time.Duration, pollInterval time.Duration, logger *slog.Logger) *targetsHandler {
+	return &targetsHandler{
+		base:          base,
+		opts:          opts,
+		timeoutOffset: timeoutOffset,
+		pollInterval:  pollInterval,
+		logger:        logger,
+	}
+}
+
+// update replaces the served targets. The targets are validated before any
+// exporter is updated, so a failed update leaves the current targets in place.
+// The exporters are built without holding h.mutex, which scrapes wait on, so
+// updates must not run concurrently: the reloader serializes them.
+func (h *targetsHandler) update(targets []Target) error {
+	for _, target := range targets {
+		if err := validateAPI(target.URL, target.Secret); err != nil {
+			return err
+		}
+		if _, err := newHTTPClient(target.options()); err != nil {
+			return fmt.Errorf("target %s: %w", target.Name, err)
+		}
+	}
+
+	h.mutex.RLock()
+	previousTargets := h.targets
+	h.mutex.RUnlock()
+
+	scrapeTargets, err := newScrapeTargets(targets, previousTargets, h.pollInterval, h.logger)
+	if err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	h.targets = scrapeTargets
+	h.mutex.Unlock()
+
+	// Stop the polling of the exporters whose target was removed.
+	for _, previous := range previousTargets {
+		if !slices.ContainsFunc(scrapeTargets, func(t scrapeTarget) bool { return t.exporter == previous.exporter }) {
+			previous.exporter.Close()
+		}
+	}
+	return nil
+}
+
+func (h *targetsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.RLock()
+	targets := h.targets
+	h.mutex.RUnlock()
+
+	ctx, cancel := scrapeContext(r, h.timeoutOffset)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	for _, target := range targets {
+		if err := prometheus.WrapRegistererWith(target.labels, registry).Register(target.exporter.withContext(ctx)); err != nil {
+			http.Error(w, "error registering target "+target.name+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	promhttp.HandlerFor(prometheus.Gatherers{h.base, registry}, h.opts).ServeHTTP(w, r)
+}
+
+// scrapeContext bounds a scrape by the timeout Prometheus sends along with the
+// request, minus offset to leave time for sending the response.
+func scrapeContext(r *http.Request, offset time.Duration) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/promslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrapeTargetsHandler(t *testing.T, handler http.Handler) string {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestTargetsHandler(t *testing.T) {
+	server := setupTestDataServer(t)
+	defer server.Close()
+
+	handler := newTargetsHandler(prometheus.NewRegistry(), promhttp.HandlerOpts{}, 0, 0, promslog.New(&promslog.Config{}))
+	err := handler.update([]Target{
+		{
+			Name:   "edge-1",
+			URL:    server.URL,
+			Labels: map[string]string{"region": "eu"},
+			Module: Module{Secret: MockZlmAPIServerSecret, Collectors: []string{SubsystemVersion}},
+		},
+		{
+			Name:   "edge-2",
+			URL:    server.URL,
+			Labels: map[string]string{"region": "us"},
+			Module: Module{Secret: MockZlmAPIServerSecret, Collectors: []string{SubsystemNetworkThreads}},
+		},
+	})
+	assert.NoError(t, err)
+
+	body := scrapeTargetsHandler(t, handler)
+	assert.Contains(t, body, `zlm_version_info{branchName="master",buildTime="2024-06-11T21:28:30",commitHash="c446f6b",region="eu"} 1`)
+	assert.Contains(t, body, `zlm_network_threads_total{region="us"} 8`)
+	assert.Contains(t, body, `zlm_up{region="eu"} 1`)
+	assert.Contains(t, body, `zlm_up{region="us"} 1`)
+	assert.NotContains(t, body, `zlm_network_threads_total{region="eu"}`)
+}
+
+func TestTargetsHandlerUpdate(t *testing.T) {
+	server := setupTestDataServer(t)
+	defer server.Close()
+
+	handler := newTargetsHandler(prometheus.NewRegistry(), promhttp.HandlerOpts{}, 0, 0, promslog.New(&promslog.Config{}))
+	target := Target{
+		Name:   "edge",
+		URL:    server.URL,
+		Labels: map[string]string{"region": "eu"},
+		Module: Module{Secret: MockZlmAPIServerSecret, Collectors: []string{SubsystemVersion}},
+	}
+	assert.NoError(t, handler.update([]Target{target}))
+	exporter := handler.targets[0].exporter
+	scrapeTargetsHandler(t, handler)
+
+	// the exporter of a target is kept across updates, along with its counters
+	target.Labels = map[string]string{"region": "us"}
+	assert.NoError(t, handler.update([]Target{target}))
+	assert.Same(t, exporter, handler.targets[0].exporter)
+	body := scrapeTargetsHandler(t, handler)
+	assert.Contains(t, body, `zlm_exporter_scrapes_total{region="us"} 2`)
+
+	// a failed update keeps the current targets
+	assert.Error(t, handler.update([]Target{{Name: "edge", URL: server.URL}}))
+	assert.Equal(t, "us", handler.targets[0].labels["region"])
+	assert.Equal(t, MockZlmAPIServerSecret, exporter.config.Load().scrapeSecret)
+
+	assert.NoError(t, handler.update(nil))
+	assert.Empty(t, handler.targets)
+}
+
+// setupBlockingServer returns a server answering no request until the test ends.
+func setupBlockingServer(t *testing.T) *httptest.Server {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+	return server
+}
+
+func TestTargetsHandlerScrapeTimeout(t *testing.T) {
+	server := setupBlockingServer(t)
+
+	handler := newTargetsHandler(prometheus.NewRegistry(), promhttp.HandlerOpts{}, 100*time.Millisecond, 0, promslog.New(&promslog.Config{}))
+	err := handler.update([]Target{{
+		Name:   "edge",
+		URL:    server.URL,
+		Module: Module{Secret: MockZlmAPIServerSecret, Timeout: time.Minute},
+	}})
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.3")
+	rec := httptest.NewRecorder()
+
+	begin := time.Now()
+	handler.ServeHTTP(rec, req)
+	assert.Less(t, time.Since(begin), 2*time.Second)
+
+	body, _ := io.ReadAll(rec.Body)
+	assert.Contains(t, string(body), "zlm_up 0")
+}
+
+func TestTargetsHandlerUpdateDuringFetch(t *testing.T) {
+	server := setupBlockingServer(t)
+
+	handler := newTargetsHandler(prometheus.NewRegistry(), promhttp.HandlerOpts{}, 0, 0, promslog.New(&promslog.Config{}))
+	target := Target{
+		Name:   "edge",
+		URL:    server.URL,
+		Module: Module{Secret: MockZlmAPIServerSecret, Timeout: time.Minute, Collectors: []string{SubsystemVersion}},
+	}
+	assert.NoError(t, handler.update([]Target{target}))
+
+	// the scrape gives up on its deadline, leaving the shared fetch in flight
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.2")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	begin := time.Now()
+	target.Timeout = time.Second
+	assert.NoError(t, handler.update([]Target{target}))
+	assert.Less(t, time.Since(begin), time.Second, "an update must not wait for the fetches in flight")
+	assert.Equal(t, time.Second, handler.targets[0].exporter.config.Load().options.Timeout)
+}
+
+func TestScrapeContext(t *testing.T) {
+	tests := []struct {
+		name             string
+		header           string
+		offset           time.Duration
+		expectedDeadline bool
+		expectedTimeout  time.Duration
+	}{
+		{name: "no header", header: "", expectedDeadline: false},
+		{name: "invalid header", header: "abc", expectedDeadline: false},
+		{name: "header with offset", header: "10", offset: 500 * time.Millisecond, expectedDeadline: true, expectedTimeout: 9500 * time.Millisecond},
+		{name: "offset larger than header", header: "0.2", offset: time.Second, expectedDeadline: true, expectedTimeout: 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+
+			ctx, cancel := scrapeContext(req, tt.offset)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			assert.Equal(t, tt.expectedDeadline, ok)
+			if ok {
+				assert.InDelta(t, tt.expectedTimeout.Seconds(), time.Until(deadline).Seconds(), 0.1)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// streamTrafficRetention is how long the traffic of a stream which is no longer
+// listed by ZLMediaKit is kept, so that a stream coming back after a restart
+// continues its counter.
+const streamTrafficRetention = time.Hour
+
+type streamTrafficKey struct {
+	vhost, app, stream, schema string
+}
+
+type streamTrafficState struct {
+	total       float64
+	totalBytes  float64
+	bytesSpeed  float64
+	createStamp int
+	lastSeen    time.Time
+}
+
+// streamTraffic accumulates the bytes transferred by every stream across the
+// scrapes. The totalBytes reported by recent ZLMediaKit versions is used when
+// available, otherwise bytesSpeed is integrated between two fetches of the
+// media list. Restarts of a stream are detected by its createStamp and never
+// decrease the total.
+type streamTraffic struct {
+	mutex   sync.Mutex
+	streams map[streamTrafficKey]*streamTrafficState
+}
+
+func newStreamTraffic() *streamTraffic {
+	return &streamTraffic{streams: make(map[streamTrafficKey]*streamTrafficState)}
+}
+
+func (t *streamTraffic) update(streams APIStreamInfoObjs, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, stream := range streams {
+		key := streamTrafficKey{vhost: stream.Vhost, app: stream.App, stream: stream.Stream, schema: stream.Schema}
+		state, seen := t.streams[key]
+		if !seen {
+			state = &streamTrafficState{}
+			t.streams[key] = state
+		}
+		restarted := seen && state.createStamp != stream.CreateStamp
+
+		switch {
+		case stream.TotalBytes != nil:
+			totalBytes := *stream.TotalBytes
+			if !seen || restarted || totalBytes < state.totalBytes {
+				state.total += totalBytes
+			} else {
+				state.total += totalBytes - state.totalBytes
+			}
+			state.totalBytes = totalBytes
+		case seen:
+			elapsed := now.Sub(state.lastSeen).Seconds()
+			bytesSpeed := (state.bytesSpeed + stream.BytesSpeed) / 2
+			if restarted {
+				elapsed = min(elapsed, float64(stream.AliveSecond))
+				bytesSpeed = stream.BytesSpeed
+			}
+			if elapsed > 0 {
+				state.total += bytesSpeed * elapsed
+			}
+		}
+		state.bytesSpeed = stream.BytesSpeed
+		state.createStamp = stream.CreateStamp
+		state.lastSeen = now
+	}
+
+	for key, state := range t.streams {
+		if now.Sub(state.lastSeen) > streamTrafficRetention {
+			delete(t.streams, key)
+		}
+	}
+}
+
+// bytes returns the bytes transferred by a stream since it was first seen.
+func (t *streamTraffic) bytes(vhost, app, stream, schema string) float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if state, ok := t.streams[streamTrafficKey{vhost: vhost, app: app, stream: stream, schema: schema}]; ok {
+		return state.total
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamTrafficIntegrated(t *testing.T) {
+	traffic := newStreamTraffic()
+	now := time.Now()
+	stream := func(bytesSpeed float64, createStamp, aliveSecond int) APIStreamInfoObjs {
+		return APIStreamInfoObjs{{Vhost: "v", App: "live", Stream: "s", Schema: "rtmp", BytesSpeed: bytesSpeed, CreateStamp: createStamp, AliveSecond: aliveSecond}}
+	}
+
+	traffic.update(stream(100, 1, 10), now)
+	assert.Equal(t, 0.0, traffic.bytes("v", "live", "s", "rtmp"), "the first fetch has nothing to integrate")
+
+	traffic.update(stream(300, 1, 20), now.Add(10*time.Second))
+	assert.Equal(t, 2000.0, traffic.bytes("v", "live", "s", "rtmp"))
+
+	traffic.update(stream(50, 2, 4), now.Add(20*time.Second))
+	assert.Equal(t, 2200.0, traffic.bytes("v", "live", "s", "rtmp"), "a restarted stream only adds the bytes since its restart")
+
+	assert.Equal(t, 0.0, traffic.bytes("v", "live", "s", "rtsp"))
+}
+
+func TestStreamTrafficTotalBytes(t *testing.T) {
+	traffic := newStreamTraffic()
+	now := time.Now()
+	stream := func(totalBytes float64, createStamp int) APIStreamInfoObjs {
+		return APIStreamInfoObjs{{Vhost: "v", App: "live", Stream: "s", Schema: "rtmp", BytesSpeed: 1000, CreateStamp: createStamp, TotalBytes: &totalBytes}}
+	}
+
+	traffic.update(stream(5000, 1), now)
+	assert.Equal(t, 5000.0, traffic.bytes("v", "live", "s", "rtmp"))
+
+	traffic.update(stream(8000, 1), now.Add(time.Second))
+	assert.Equal(t, 8000.0, traffic.bytes("v", "live", "s", "rtmp"))
+
+	traffic.update(stream(1000, 2), now.Add(2*time.Second))
+	assert.Equal(t, 9000.0, traffic.bytes("v", "live", "s", "rtmp"), "the counter must not decrease when the stream restarts")
+
+	traffic.update(nil, now.Add(2*time.Second+streamTrafficRetention+time.Second))
+	assert.Equal(t, 0.0, traffic.bytes("v", "live", "s", "rtmp"), "streams gone for longer than the retention are dropped")
+}
+
+func TestExtractStreamBytes(t *testing.T) {
+	totalBytes := 4096.0
+	mockResponse := ZLMAPIResponse[APIStreamInfoObjs]{
+		Code: 0,
+		Data: APIStreamInfoObjs{{Vhost: "__defaultVhost__", App: "live", Stream: "test", Schema: "rtmp", TotalBytes: &totalBytes}},
+	}
+	server := setupTestServer(t, ZlmAPIEndpointGetMediaList, mockResponse)
+	defer server.Close()
+
+	exporter, err := NewExporter(server.URL, MockZlmAPIServerSecret, promslog.New(&promslog.Config{}), Options{Collectors: []string{SubsystemStream}})
+	assert.NoError(t, err)
+
+	expected := `
+# HELP zlm_stream_bytes_total Bytes transferred by a stream, from totalBytes or integrated from bytesSpeed
+# TYPE zlm_stream_bytes_total counter
+zlm_stream_bytes_total{app="live",schema="rtmp",stream="test",vhost="__defaultVhost__"} 4096
+`
+	assert.NoError(t, testutil.CollectAndCompare(exporter, strings.NewReader(expected), "zlm_stream_bytes_total"))
+}
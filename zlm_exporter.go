@@ -1,18 +1,24 @@
 package main
 
 import (
+	"cmp"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"os"
 	"reflect"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"context"
@@ -20,16 +26,22 @@ import (
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	promconfig "github.com/prometheus/common/config"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/version"
 	promweb "github.com/prometheus/exporter-toolkit/web"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
 	ZlmAPISuccessCode = 0
 )
 
+const (
+	DefaultScrapeTimeout = 12 * time.Second
+)
+
 const (
 	ZlmAPIEndpointVersion           = "index/api/version"
 	ZlmAPIEndpointGetApiList        = "index/api/getApiList"
@@ -39,6 +51,11 @@ const (
 	ZlmAPIEndpointGetAllSession     = "index/api/getAllSession"
 	ZlmAPIEndpointGetMediaList      = "index/api/getMediaList"
 	ZlmAPIEndpointListRtpServer     = "index/api/listRtpServer"
+	ZlmAPIEndpointGetMp4RecordFile  = "index/api/getMP4RecordFile"
+	ZlmAPIEndpointGetServerConfig   = "index/api/getServerConfig"
+	ZlmAPIEndpointListStreamProxy   = "index/api/listStreamProxy"
+	ZlmAPIEndpointListPusherProxy   = "index/api/listStreamPusherProxy"
+	ZlmAPIEndpointListFFmpegSource  = "index/api/listFFmpegSource"
 )
 
 const (
@@ -51,6 +68,12 @@ const (
 	SubsystemSession        = "session"
 	SubsystemStream         = "stream"
 	SubsystemRtp            = "rtp"
+	SubsystemRecord         = "record"
+	SubsystemConfig         = "config"
+	SubsystemProxy          = "proxy"
+	SubsystemFFmpegSource   = "ffmpeg_source"
+	SubsystemScrape         = "scrape"
+	SubsystemExporter       = "exporter"
 )
 
 func getEnv(key string, defaultVal string) string {
@@ -81,16 +104,9 @@ var (
 
 var metrics []*prometheus.Desc
 
-var (
-	scrapeErrors = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Namespace: Namespace,
-			Name:      "scrape_errors_total",
-			Help:      "Number of errors while scraping ZLMediaKit.",
-		},
-		[]string{"endpoint"},
-	)
-)
+// metricLabels holds the label names of the metrics exported for a target, the
+// labels of a target must not reuse them. endpoint is the label of scrape_errors_total.
+var metricLabels = map[string]bool{"endpoint": true}
 
 var (
 	ZLMediaKitInfo = newMetricDescr(Namespace, SubsystemVersion, "info", "ZLMediaKit version info.", []string{"branchName", "buildTime", "commitHash"})
@@ -100,11 +116,23 @@ var (
 	NetworkThreadsTotal      = newMetricDescr(Namespace, SubsystemNetworkThreads, "total", "Total number of network threads", []string{})
 	NetworkThreadsLoadTotal  = newMetricDescr(Namespace, SubsystemNetworkThreads, "load_total", "Total of network threads load", []string{})
 	NetworkThreadsDelayTotal = newMetricDescr(Namespace, SubsystemNetworkThreads, "delay_total", "Total of network threads delay", []string{})
+	NetworkThreadsLoadMax    = newMetricDescr(Namespace, SubsystemNetworkThreads, "load_max", "Highest load of the network threads", []string{})
+	NetworkThreadsLoadAvg    = newMetricDescr(Namespace, SubsystemNetworkThreads, "load_avg", "Average load of the network threads", []string{})
+	NetworkThreadsDelayMax   = newMetricDescr(Namespace, SubsystemNetworkThreads, "delay_max", "Highest delay of the network threads", []string{})
+	NetworkThreadsDelayAvg   = newMetricDescr(Namespace, SubsystemNetworkThreads, "delay_avg", "Average delay of the network threads", []string{})
+	NetworkThreadsLoad       = newMetricDescr(Namespace, SubsystemNetworkThreads, "load", "Load of a network thread", []string{"thread"})
+	NetworkThreadsDelay      = newMetricDescr(Namespace, SubsystemNetworkThreads, "delay", "Delay of a network thread", []string{"thread"})
 
 	// work threads metrics
 	WorkThreadsTotal      = newMetricDescr(Namespace, SubsystemWorkThreads, "total", "Total number of work threads", []string{})
 	WorkThreadsLoadTotal  = newMetricDescr(Namespace, SubsystemWorkThreads, "load_total", "Total of work threads load", []string{})
 	WorkThreadsDelayTotal = newMetricDescr(Namespace, SubsystemWorkThreads, "delay_total", "Total of work threads delay", []string{})
+	WorkThreadsLoadMax    = newMetricDescr(Namespace, SubsystemWorkThreads, "load_max", "Highest load of the work threads", []string{})
+	WorkThreadsLoadAvg    = newMetricDescr(Namespace, SubsystemWorkThreads, "load_avg", "Average load of the work threads", []string{})
+	WorkThreadsDelayMax   = newMetricDescr(Namespace, SubsystemWorkThreads, "delay_max", "Highest delay of the work threads", []string{})
+	WorkThreadsDelayAvg   = newMetricDescr(Namespace, SubsystemWorkThreads, "delay_avg", "Average delay of the work threads", []string{})
+	WorkThreadsLoad       = newMetricDescr(Namespace, SubsystemWorkThreads, "load", "Load of a work thread", []string{"thread"})
+	WorkThreadsDelay      = newMetricDescr(Namespace, SubsystemWorkThreads, "delay", "Delay of a work thread", []string{"thread"})
 
 	// statistics metrics
 	StatisticsBuffer                = newMetricDescr(Namespace, SubsystemStatistics, "buffer", "Statistics buffer", []string{})
@@ -123,10 +151,13 @@ var (
 	StatisticsTcpSession            = newMetricDescr(Namespace, SubsystemStatistics, "tcp_session", "Statistics TcpSession", []string{})
 	StatisticsUdpServer             = newMetricDescr(Namespace, SubsystemStatistics, "udp_server", "Statistics UdpServer", []string{})
 	StatisticsUdpSession            = newMetricDescr(Namespace, SubsystemStatistics, "udp_session", "Statistics UdpSession", []string{})
+	StatisticsObjects               = newMetricDescr(Namespace, SubsystemStatistics, "objects", "Number of instances of an internal object type", []string{"type"})
 
 	// session metrics
-	SessionInfo  = newMetricDescr(Namespace, SubsystemSession, "info", "Session info", []string{"id", "identifier", "local_ip", "local_port", "peer_ip", "peer_port", "typeid"})
-	SessionTotal = newMetricDescr(Namespace, SubsystemSession, "total", "Total number of sessions", []string{})
+	SessionInfo    = newMetricDescr(Namespace, SubsystemSession, "info", "Session info", []string{"id", "identifier", "local_ip", "local_port", "peer_ip", "peer_port", "typeid"})
+	SessionTotal   = newMetricDescr(Namespace, SubsystemSession, "total", "Total number of sessions", []string{})
+	Sessions       = newMetricDescr(Namespace, "", "sessions", "Number of sessions by type and local port", []string{"typeid", "local_port"})
+	SessionsByPeer = newMetricDescr(Namespace, "", "sessions_by_peer", "Number of sessions of the peers with the most sessions", []string{"peer_ip"})
 
 	// stream metrics
 	StreamsInfo            = newMetricDescr(Namespace, SubsystemStream, "info", "Stream basic information", []string{"vhost", "app", "stream", "schema", "origin_type", "origin_url"})
@@ -136,30 +167,105 @@ var (
 	StreamBitrate          = newMetricDescr(Namespace, SubsystemStream, "bitrate", "Stream bitrate", []string{"vhost", "app", "stream", "schema"})
 	StreamaliveSecond      = newMetricDescr(Namespace, SubsystemStream, "alive_second", "Stream alive second", []string{"vhost", "app", "stream", "schema"})
 	StreamCreateStamp      = newMetricDescr(Namespace, SubsystemStream, "create_stamp", "Stream create stamp", []string{"vhost", "app", "stream", "schema"})
+	StreamBytesTotal       = newMetricDescr(Namespace, SubsystemStream, "bytes_total", "Bytes transferred by a stream, from totalBytes or integrated from bytesSpeed", []string{"vhost", "app", "stream", "schema"})
 	StreamTotal            = newMetricDescr(Namespace, SubsystemStream, "total", "Total number of streams", []string{})
+	StreamRecording        = newMetricDescr(Namespace, SubsystemStream, "recording", "Whether a stream is being recorded (1: recording, 0: not recording)", []string{"vhost", "app", "stream", "format"})
+	StreamOriginInfo       = newMetricDescr(Namespace, SubsystemStream, "origin_info", "Socket of the publisher of a stream, identifier matches zlm_session_info", []string{"vhost", "app", "stream", "identifier", "local_ip", "local_port", "peer_ip", "peer_port"})
+
+	// stream track metrics, the tracks are shared by all the schemas of a stream
+	StreamTrackFPS         = newMetricDescr(Namespace, SubsystemStream, "track_fps", "Frame rate of a video track", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackWidth       = newMetricDescr(Namespace, SubsystemStream, "track_width", "Width of a video track in pixels", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackHeight      = newMetricDescr(Namespace, SubsystemStream, "track_height", "Height of a video track in pixels", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackGopSize     = newMetricDescr(Namespace, SubsystemStream, "track_gop_size", "Number of frames in a GOP of a video track", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackGopInterval = newMetricDescr(Namespace, SubsystemStream, "track_gop_interval_seconds", "Interval between key frames of a video track", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackKeyFrames   = newMetricDescr(Namespace, SubsystemStream, "track_key_frames_total", "Number of key frames of a video track since the track started", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackFrames      = newMetricDescr(Namespace, SubsystemStream, "track_frames_total", "Number of frames of a track since the track started", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackSampleRate  = newMetricDescr(Namespace, SubsystemStream, "track_sample_rate", "Sample rate of an audio track in Hz", []string{"vhost", "app", "stream", "codec_type", "codec"})
+	StreamTrackChannels    = newMetricDescr(Namespace, SubsystemStream, "track_channels", "Number of channels of an audio track", []string{"vhost", "app", "stream", "codec_type", "codec"})
 
 	// rtp metrics
 	RtpServerInfo  = newMetricDescr(Namespace, SubsystemRtp, "server_info", "RTP server info", []string{"port", "stream_id"})
 	RtpServerTotal = newMetricDescr(Namespace, SubsystemRtp, "server_total", "Total number of RTP servers", []string{})
+
+	// record metrics
+	RecordMP4Files = newMetricDescr(Namespace, SubsystemRecord, "mp4_files", "Number of MP4 files recorded today by a stream", []string{"vhost", "app", "stream"})
+
+	// config metrics
+	ConfigInfo    = newMetricDescr(Namespace, SubsystemConfig, "info", "ZLMediaKit server configuration info", []string{"media_server_id"})
+	ConfigSetting = newMetricDescr(Namespace, SubsystemConfig, "setting", "Numeric setting of the ZLMediaKit server configuration", []string{"key"})
+
+	// proxy metrics, type is pull for listStreamProxy and push for listStreamPusherProxy
+	ProxyInfo        = newMetricDescr(Namespace, SubsystemProxy, "info", "Stream proxy info, url has no credentials nor query", []string{"type", "key", "vhost", "app", "stream", "url"})
+	ProxyStatus      = newMetricDescr(Namespace, SubsystemProxy, "status", "Status of a stream proxy reported by ZLMediaKit, 0 while playing or publishing", []string{"type", "key"})
+	ProxyUp          = newMetricDescr(Namespace, SubsystemProxy, "up", "Whether a stream proxy is playing or publishing (1: up, 0: down)", []string{"type", "key"})
+	ProxyRetries     = newMetricDescr(Namespace, SubsystemProxy, "retries_total", "Number of times a stream proxy pulled or published again", []string{"type", "key"})
+	ProxyLiveSeconds = newMetricDescr(Namespace, SubsystemProxy, "live_seconds", "Seconds since a stream proxy started playing or publishing", []string{"type", "key"})
+	ProxyTotal       = newMetricDescr(Namespace, SubsystemProxy, "total", "Total number of stream proxies", []string{"type"})
+
+	// ffmpeg source metrics, vhost, app and stream are the destination stream of the source
+	FFmpegSourceInfo     = newMetricDescr(Namespace, SubsystemFFmpegSource, "info", "FFmpeg source info, urls have no credentials nor query", []string{"key", "vhost", "app", "stream", "src_url", "dst_url", "cmd_key"})
+	FFmpegSourceUp       = newMetricDescr(Namespace, SubsystemFFmpegSource, "up", "Whether the destination stream of an FFmpeg source is registered (1: up, 0: down)", []string{"key"})
+	FFmpegSourceRestarts = newMetricDescr(Namespace, SubsystemFFmpegSource, "restarts_total", "Number of times the destination stream of an FFmpeg source was created again since the exporter started", []string{"key"})
+	FFmpegSourceTotal    = newMetricDescr(Namespace, SubsystemFFmpegSource, "total", "Total number of FFmpeg sources", []string{})
+
+	// scrape metrics
+	ScrapeCollectorSuccess  = newMetricDescr(Namespace, SubsystemScrape, "collector_success", "Whether a collector succeeded", []string{"collector"})
+	ScrapeCollectorDuration = newMetricDescr(Namespace, SubsystemScrape, "collector_duration_seconds", "Duration of a collector scrape", []string{"collector"})
+
+	// exporter metrics
+	ExporterCollectorEnabled = newMetricDescr(Namespace, SubsystemExporter, "collector_enabled", "Whether a collector is enabled", []string{"collector"})
+	ExporterSnapshotAge      = newMetricDescr(Namespace, SubsystemExporter, "snapshot_age_seconds", "Age of the polled snapshot served on scrape", []string{})
+	ExporterCircuitOpen      = newMetricDescr(Namespace, SubsystemExporter, "circuit_open", "Whether the circuit breaker stops the requests to the ZLMediaKit API", []string{"target"})
 )
 
 type Exporter struct {
-	scrapeURI    string
-	scrapeSecret string
-	client       http.Client
-	mutex        sync.RWMutex
+	config atomic.Pointer[exporterConfig]
+	mutex  sync.RWMutex
 
 	up                prometheus.Gauge
 	totalScrapes      prometheus.Counter
-	totalScrapeErrors prometheus.CounterVec
+	totalScrapeErrors *prometheus.CounterVec
 	log               *slog.Logger
-	options           Options
+
+	snapshot       *snapshot
+	cancelPolling  context.CancelFunc
+	group          singleflight.Group
+	traffic        *streamTraffic
+	ffmpegRestarts *ffmpegRestarts
+	// probe is set on the exporters serving a single probe, which leave out the
+	// counters accumulated across scrapes as they would start over on every probe.
+	probe bool
 
 	buildInfo BuildInfo
 }
 
+// exporterConfig is the ZLMediaKit API an exporter scrapes and the options it scrapes it with.
+// It is never modified: Update swaps it for a new one, so that a fetch in flight
+// does not hold up an update.
+type exporterConfig struct {
+	scrapeURI    string
+	scrapeSecret string
+	client       http.Client
+	options      Options
+}
+
 type Options struct {
-	SSLVerify bool
+	TLSConfig    promconfig.TLSConfig
+	Timeout      time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+	Collectors   []string
+	PollInterval time.Duration
+}
+
+// equal reports whether two options configure the exporter the same way.
+func (o Options) equal(other Options) bool {
+	return o.TLSConfig == other.TLSConfig &&
+		o.Timeout == other.Timeout &&
+		o.Retries == other.Retries &&
+		o.RetryBackoff == other.RetryBackoff &&
+		slices.Equal(o.Collectors, other.Collectors) &&
+		o.PollInterval == other.PollInterval
 }
 
 type BuildInfo struct {
@@ -169,27 +275,17 @@ type BuildInfo struct {
 }
 
 func NewExporter(uri string, secret string, logger *slog.Logger, options Options) (*Exporter, error) {
-	if uri == "" {
-		return nil, fmt.Errorf("ZlMediaKit API uri is required")
-	}
-
-	if secret == "" {
-		return nil, fmt.Errorf("ZlMediaKit API secret is required")
+	exporter := newExporter(logger)
+	if err := exporter.Update(uri, secret, options); err != nil {
+		return nil, err
 	}
 
-	client := http.Client{}
-
-	if options.SSLVerify {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-	}
-
-	exporter := &Exporter{
-		scrapeURI:    uri,
-		scrapeSecret: secret,
-		client:       client,
+	return exporter, nil
+}
 
+// newExporter returns an exporter without any API to scrape, which is set with Update or setConfig.
+func newExporter(logger *slog.Logger) *Exporter {
+	return &Exporter{
 		up: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: Namespace,
 			Name:      "up",
@@ -202,29 +298,83 @@ func NewExporter(uri string, secret string, logger *slog.Logger, options Options
 			Help:      "Current total ZLMediaKit scrapes.",
 		}),
 
-		totalScrapeErrors: *prometheus.NewCounterVec(prometheus.CounterOpts{
+		totalScrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: Namespace,
 			Name:      "scrape_errors_total",
 			Help:      "Number of errors while scraping ZLMediaKit.",
 		}, []string{"endpoint"}),
 
-		log: logger,
+		log:            logger,
+		traffic:        newStreamTraffic(),
+		ffmpegRestarts: newFFmpegRestarts(),
 
 		buildInfo: BuildInfo{
 			Version:   BuildVersion,
 			CommitSha: BuildCommitSha,
 			Date:      BuildDate,
 		},
+	}
+}
 
-		options: options,
+// Update swaps the ZLMediaKit API the exporter scrapes, keeping its counters.
+func (e *Exporter) Update(uri string, secret string, options Options) error {
+	if err := validateAPI(uri, secret); err != nil {
+		return err
 	}
 
-	return exporter, nil
+	client, err := newHTTPClient(options)
+	if err != nil {
+		return err
+	}
+	e.setConfig(&exporterConfig{
+		scrapeURI:    uri,
+		scrapeSecret: secret,
+		client:       client,
+		options:      options,
+	})
+	return nil
+}
+
+// setConfig swaps the config of the exporter. The fetches in flight finish with the
+// previous config. The background polling restarts unless neither the API nor the options changed.
+func (e *Exporter) setConfig(config *exporterConfig) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	previous := e.config.Swap(config)
+	if previous == nil || config.scrapeURI != previous.scrapeURI || config.scrapeSecret != previous.scrapeSecret ||
+		!config.options.equal(previous.options) {
+		e.startPolling()
+	}
+}
+
+// newHTTPClient returns the client used to reach the ZLMediaKit API with the TLS settings of options.
+func newHTTPClient(options Options) (http.Client, error) {
+	tlsConfig, err := promconfig.NewTLSConfig(&options.TLSConfig)
+	if err != nil {
+		return http.Client{}, fmt.Errorf("error creating TLS config: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return http.Client{Transport: transport}, nil
+}
+
+func validateAPI(uri string, secret string) error {
+	if uri == "" {
+		return fmt.Errorf("ZlMediaKit API uri is required")
+	}
+
+	if secret == "" {
+		return fmt.Errorf("ZlMediaKit API secret is required")
+	}
+	return nil
 }
 
 func newMetricDescr(namespace, subsystem, metricName, docString string, labels []string) *prometheus.Desc {
 	newDesc := prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, metricName), docString, labels, nil)
 	metrics = append(metrics, newDesc)
+	for _, label := range labels {
+		metricLabels[label] = true
+	}
 	return newDesc
 }
 
@@ -234,76 +384,310 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	}
 	ch <- e.up.Desc()
 	ch <- e.totalScrapes.Desc()
+	e.totalScrapeErrors.Describe(ch)
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	e.mutex.Lock()
-	defer e.mutex.Unlock()
-	up := e.scrape(ch)
-	ch <- prometheus.MustNewConstMetric(e.up.Desc(), prometheus.GaugeValue, up)
+	e.collect(context.Background(), ch)
+}
+
+// collect scrapes ZLMediaKit within the deadline of ctx, if any, and the timeout of the exporter.
+// Concurrent scrapes share their requests to ZLMediaKit. In polling mode it serves the last snapshot instead.
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	config := e.config.Load()
+	ch <- prometheus.MustNewConstMetric(ExporterCircuitOpen, prometheus.GaugeValue, boolToFloat64(breakers.get(config.scrapeURI).isOpen()), config.scrapeURI)
+	e.mutex.RLock()
+	if config.options.PollInterval > 0 {
+		defer e.mutex.RUnlock()
+		if e.snapshot != nil {
+			ch <- prometheus.MustNewConstMetric(ExporterSnapshotAge, prometheus.GaugeValue, time.Since(e.snapshot.timestamp).Seconds())
+		}
+		e.emit(e.snapshot, ch)
+		e.emitScrapeCounters(ch)
+		return
+	}
+	e.mutex.RUnlock()
+
+	e.emit(e.fetch(ctx), ch)
+	e.emitScrapeCounters(ch)
+}
+
+// emitScrapeCounters writes the counters of the scrapes of the exporter, left out
+// of the probes as they would start over on every probe.
+func (e *Exporter) emitScrapeCounters(ch chan<- prometheus.Metric) {
+	if e.probe {
+		return
+	}
 	ch <- e.totalScrapes
+	e.totalScrapeErrors.Collect(ch)
 }
 
-func (e *Exporter) scrape(ch chan<- prometheus.Metric) (up float64) {
+// withContext returns a collector scraping the exporter within ctx, used to
+// bind a scrape to the HTTP request it serves.
+func (e *Exporter) withContext(ctx context.Context) prometheus.Collector {
+	return &contextExporter{Exporter: e, ctx: ctx}
+}
+
+type contextExporter struct {
+	*Exporter
+	ctx context.Context
+}
+
+func (c *contextExporter) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.ctx, ch)
+}
+
+// snapshot holds the data decoded by every collector in a scrape.
+type snapshot struct {
+	timestamp time.Time
+	up        float64
+	results   []scrapeResult
+}
+
+type scrapeResult struct {
+	scraper  scraper
+	enabled  bool
+	data     any
+	err      error
+	duration time.Duration
+}
+
+// fetch runs the enabled collectors concurrently and returns the data they decoded.
+func (e *Exporter) fetch(ctx context.Context) *snapshot {
 	e.totalScrapes.Inc()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	s := &snapshot{
+		timestamp: time.Now(),
+		up:        1,
+		results:   make([]scrapeResult, len(scrapers)),
+	}
+
+	options := e.config.Load().options
+	timeout := options.Timeout
+	for i, sc := range scrapers {
+		s.results[i] = scrapeResult{scraper: sc, enabled: collectorEnabled(options, sc)}
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultScrapeTimeout
+	}
+	ctx, cancel := context.WithTimeout(withAPICache(ctx), timeout)
 	defer cancel()
 
 	var wg sync.WaitGroup
+	for i := range s.results {
+		result := &s.results[i]
+		if !result.enabled {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			begin := time.Now()
+			result.data, result.err = e.fetchShared(ctx, timeout, result.scraper)
+			result.duration = time.Since(begin)
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		e.log.Error("scrape timeout", "error", err)
+		s.up = 0
+	}
+	for _, result := range s.results {
+		if result.err != nil && result.scraper.core {
+			s.up = 0
+		}
+	}
+	return s
+}
 
-	wg.Add(8)
-	go func() {
-		defer wg.Done()
-		e.extractVersion(ctx, ch)
-	}()
-	go func() {
-		defer wg.Done()
-		e.extractAPIStatus(ctx, ch)
-	}()
-	go func() {
-		defer wg.Done()
-		e.extractNetworkThreads(ctx, ch)
-	}()
-	go func() {
-		defer wg.Done()
-		e.extractWorkThreads(ctx, ch)
-	}()
-	go func() {
-		defer wg.Done()
-		e.extractStatistics(ctx, ch)
-	}()
-	go func() {
-		defer wg.Done()
-		e.extractSession(ctx, ch)
-	}()
-	go func() {
-		defer wg.Done()
-		e.extractStream(ctx, ch)
-	}()
-	go func() {
-		defer wg.Done()
-		e.extractRtp(ctx, ch)
-	}()
-
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
-	}()
+// fetchShared runs the fetch of a collector once for all the concurrent scrapes
+// of the exporter. The shared fetch is detached from the scrape that started it
+// and bounded by the timeout of the exporter, so that a scrape with a longer
+// deadline joining it is not cut short. Every scrape waits for it until its own deadline.
+func (e *Exporter) fetchShared(ctx context.Context, timeout time.Duration, s scraper) (any, error) {
+	results := e.group.DoChan(s.name, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		return s.fetch(ctx, e)
+	})
 
 	select {
+	case result := <-results:
+		return result.Val, result.Err
 	case <-ctx.Done():
-		e.log.Error("scrape timeout", "error", ctx.Err())
-		return 0
-	case <-done:
-		return 1
+		return nil, ctx.Err()
 	}
 }
 
+// emit writes the metrics of a snapshot, zlm_up is 0 without any snapshot.
+func (e *Exporter) emit(s *snapshot, ch chan<- prometheus.Metric) {
+	if s == nil {
+		ch <- prometheus.MustNewConstMetric(e.up.Desc(), prometheus.GaugeValue, 0)
+		return
+	}
+
+	for _, result := range s.results {
+		name := result.scraper.name
+		ch <- prometheus.MustNewConstMetric(ExporterCollectorEnabled, prometheus.GaugeValue, boolToFloat64(result.enabled), name)
+		if !result.enabled {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(ScrapeCollectorDuration, prometheus.GaugeValue, result.duration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(ScrapeCollectorSuccess, prometheus.GaugeValue, boolToFloat64(result.err == nil), name)
+		if result.err == nil {
+			result.scraper.emit(e, result.data, ch)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(e.up.Desc(), prometheus.GaugeValue, s.up)
+}
+
+// startPolling replaces the poller of the exporter according to its options.
+// The previous snapshot is served until the new poller stores one.
+// The caller must hold e.mutex.
+func (e *Exporter) startPolling() {
+	e.stopPolling()
+	interval := e.config.Load().options.PollInterval
+	if interval <= 0 {
+		e.snapshot = nil
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancelPolling = cancel
+	go e.poll(ctx, interval)
+}
+
+// stopPolling stops the poller, if any. The caller must hold e.mutex.
+func (e *Exporter) stopPolling() {
+	if e.cancelPolling != nil {
+		e.cancelPolling()
+		e.cancelPolling = nil
+	}
+}
+
+// Close stops the background polling of the exporter.
+func (e *Exporter) Close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.stopPolling()
+}
+
+// poll fetches ZLMediaKit every interval until ctx is canceled, storing the
+// result as the snapshot served by Collect.
+func (e *Exporter) poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s := e.fetch(ctx)
+
+		e.mutex.Lock()
+		// A canceled poller must not overwrite the snapshot of its replacement.
+		if ctx.Err() == nil {
+			e.snapshot = s
+		}
+		e.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scraper is a named collector. zlm_up is 0 when a core collector fails.
+// fetch decodes the data of the ZLMediaKit API, emit turns it into metrics.
+type scraper struct {
+	name    string
+	fetch   func(ctx context.Context, e *Exporter) (any, error)
+	emit    func(e *Exporter, data any, ch chan<- prometheus.Metric)
+	core    bool
+	enabled *bool
+}
+
+// scrapers lists every registered collector, the names are used by the
+// --collector.<name> flags and to enable collectors in the config file.
+var scrapers []scraper
+
+func init() {
+	registerCollector(SubsystemVersion, true, true, ZlmAPIEndpointVersion, (*Exporter).emitVersion)
+	registerCollector(SubsystemApi, true, false, ZlmAPIEndpointGetApiList, (*Exporter).emitAPIStatus)
+	registerCollector(SubsystemNetworkThreads, true, false, ZlmAPIEndpointGetNetworkThreads, (*Exporter).emitNetworkThreads)
+	registerCollector(SubsystemWorkThreads, true, false, ZlmAPIEndpointGetWorkThreads, (*Exporter).emitWorkThreads)
+	registerCollector(SubsystemStatistics, true, true, ZlmAPIEndpointGetStatistics, (*Exporter).emitStatistics)
+	registerCollector(SubsystemSession, true, false, ZlmAPIEndpointGetAllSession, (*Exporter).emitSession)
+	registerCollectorFunc(SubsystemStream, true, false, fetchStreams, (*Exporter).emitStream)
+	registerCollector(SubsystemRtp, true, false, ZlmAPIEndpointListRtpServer, (*Exporter).emitRtp)
+	registerCollectorFunc(SubsystemRecord, false, false, fetchRecordFiles, (*Exporter).emitRecordFiles)
+	registerCollector(SubsystemConfig, true, false, ZlmAPIEndpointGetServerConfig, (*Exporter).emitServerConfig)
+	registerCollectorFunc(SubsystemProxy, true, false, fetchStreamProxies, (*Exporter).emitStreamProxies)
+	registerCollectorFunc(SubsystemFFmpegSource, true, false, fetchFFmpegSources, (*Exporter).emitFFmpegSources)
+
+	kingpin.Flag("collector.threads.per-thread", "Export the load and delay of every network and work thread with a thread label.").
+		Default(strconv.FormatBool(getEnvBool("ZLM_EXPORTER_THREADS_PER_THREAD", perThreadMetrics))).BoolVar(&perThreadMetrics)
+	kingpin.Flag("collector.session.info", "Export zlm_session_info with one series per connection, disable it on nodes with many players.").
+		Default(strconv.FormatBool(getEnvBool("ZLM_EXPORTER_SESSION_INFO", sessionInfo))).BoolVar(&sessionInfo)
+	kingpin.Flag("collector.session.top-peers", "Number of peers with the most sessions exported by zlm_sessions_by_peer, 0 disables it.").
+		Default(getEnv("ZLM_EXPORTER_SESSION_TOP_PEERS", strconv.Itoa(sessionTopPeers))).IntVar(&sessionTopPeers)
+	kingpin.Flag("collector.config.settings", "Comma separated settings of getServerConfig exported by the config collector, secrets are never exported.").
+		Default(getEnv("ZLM_EXPORTER_CONFIG_SETTINGS", configSettings)).StringVar(&configSettings)
+}
+
+// registerCollector adds a collector of a ZLMediaKit API endpoint along with its
+// --collector.<name> flag, kingpin provides the matching --no-collector.<name> flag.
+func registerCollector[T ZLMAPIResponseData](name string, isDefaultEnabled bool, core bool, endpoint string, emit func(e *Exporter, data T, ch chan<- prometheus.Metric)) {
+	fetch := func(ctx context.Context, e *Exporter) (T, error) {
+		return fetchAPI[T](ctx, e, endpoint)
+	}
+	registerCollectorFunc(name, isDefaultEnabled, core, fetch, emit)
+}
+
+// registerCollectorFunc adds a collector whose data is fetched by fetch, for
+// collectors built from several requests to the ZLMediaKit API.
+func registerCollectorFunc[T any](name string, isDefaultEnabled bool, core bool, fetch func(ctx context.Context, e *Exporter) (T, error), emit func(e *Exporter, data T, ch chan<- prometheus.Metric)) {
+	defaultEnabled := getEnvBool("ZLM_EXPORTER_COLLECTOR_"+strings.ToUpper(name), isDefaultEnabled)
+	enabled := defaultEnabled
+	kingpin.Flag("collector."+name, fmt.Sprintf("Enable the %s collector (default: %t).", name, defaultEnabled)).
+		Default(strconv.FormatBool(defaultEnabled)).BoolVar(&enabled)
+
+	scrapers = append(scrapers, scraper{
+		name: name,
+		fetch: func(ctx context.Context, e *Exporter) (any, error) {
+			return fetch(ctx, e)
+		},
+		emit: func(e *Exporter, data any, ch chan<- prometheus.Metric) {
+			emit(e, data.(T), ch)
+		},
+		core:    core,
+		enabled: &enabled,
+	})
+}
+
+func validateCollectors(names []string) error {
+	for _, name := range names {
+		if !slices.ContainsFunc(scrapers, func(s scraper) bool { return s.name == name }) {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return nil
+}
+
+// collectorEnabled reports whether an exporter with options runs a collector. The collectors
+// listed in the config file take precedence over the --collector.<name> flags.
+func collectorEnabled(options Options, s scraper) bool {
+	if len(options.Collectors) > 0 {
+		return slices.Contains(options.Collectors, s.name)
+	}
+	return *s.enabled
+}
+
 type ZLMAPIResponseData interface {
 	[]string | APIVersionObj | APINetworkThreadsObjs | APIWorkThreadsObjs |
-		APIStreamInfoObjs | APIStatisticsObj | APISessionObjs | APIRtpServerObjs
+		APIStreamInfoObjs | APIStatisticsObj | APISessionObjs | APIRtpServerObjs | APIMp4RecordFileObj |
+		APIServerConfigObjs | APIStreamProxyObjs | APIFFmpegSourceObjs
 }
 
 type ZLMAPIResponse[T ZLMAPIResponseData] struct {
@@ -327,6 +711,13 @@ func (e *Exporter) mustNewConstMetric(desc *prometheus.Desc, valueType prometheu
 	}
 }
 
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func (e *Exporter) processAPIResponse(endpoint string, body io.ReadCloser, result interface{}) error {
 	decoder := json.NewDecoder(body)
 	if err := decoder.Decode(result); err != nil {
@@ -353,72 +744,180 @@ func (e *Exporter) processAPIResponse(endpoint string, body io.ReadCloser, resul
 	return nil
 }
 
-func (e *Exporter) fetchHTTP(ctx context.Context, ch chan<- prometheus.Metric, endpoint string, processFunc func(closer io.ReadCloser) error) {
-	uri := fmt.Sprintf("%s/%s", e.scrapeURI, endpoint)
-	parsedURL, err := url.Parse(uri)
+// fetchHTTP fetches an endpoint with optional query parameters, which are left out of scrape_errors_total.
+func (e *Exporter) fetchHTTP(ctx context.Context, endpoint string, query url.Values, processFunc func(closer io.ReadCloser) error) error {
+	config := e.config.Load()
+	uri := fmt.Sprintf("%s/%s", config.scrapeURI, endpoint)
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
 	if err != nil {
-		scrapeErrors.WithLabelValues(endpoint).Inc()
+		e.totalScrapeErrors.WithLabelValues(endpoint).Inc()
 		e.log.Error("error parsing URL", "err", err)
-		return
+		return err
 	}
+	req.Header.Set("secret", config.scrapeSecret)
 
-	req := &http.Request{
-		Method: http.MethodGet,
-		URL:    parsedURL,
-		Header: http.Header{
-			"secret": []string{e.scrapeSecret},
-		},
+	res, err := e.do(ctx, config, req)
+	if errors.Is(err, errCircuitOpen) {
+		e.log.Debug("skipping request to ZLMediaKit", "endpoint", endpoint, "err", err)
+		return err
 	}
-
-	res, err := e.client.Do(req)
 	if err != nil {
-		scrapeErrors.WithLabelValues(endpoint).Inc()
+		e.totalScrapeErrors.WithLabelValues(endpoint).Inc()
 		e.log.Error("error scraping ZLMediaKit", "err", err)
-		return
+		// --web.ssl-verify used to skip the verification, which it now enables.
+		if certErr := (*tls.CertificateVerificationError)(nil); errors.As(err, &certErr) {
+			e.log.Warn("the certificate of the ZLMediaKit API could not be verified, " +
+				"set --web.ssl-verify=false or insecure_skip_verify in tls_config to skip the verification")
+		}
+		return err
 	}
 	defer res.Body.Close()
 
 	if err = processFunc(res.Body); err != nil {
-		scrapeErrors.WithLabelValues(endpoint).Inc()
+		e.totalScrapeErrors.WithLabelValues(endpoint).Inc()
 		e.log.Error("error processing response", "err", err)
+		return err
 	}
+	return nil
 }
 
-type APIVersionObj struct {
-	BranchName string `json:"branchName"`
-	BuildTime  string `json:"buildTime"`
-	CommitHash string `json:"commitHash"`
-}
+// do sends req with config unless the circuit breaker of the API is open. Failed requests,
+// including the 5xx answers of ZLMediaKit or of a proxy in front of it, are retried with a
+// jittered exponential backoff as long as the deadline of ctx allows.
+func (e *Exporter) do(ctx context.Context, config *exporterConfig, req *http.Request) (*http.Response, error) {
+	breaker := breakers.get(config.scrapeURI)
+	if !breaker.allow() {
+		return nil, errCircuitOpen
+	}
 
-func (e *Exporter) extractVersion(ctx context.Context, ch chan<- prometheus.Metric) {
-	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[APIVersionObj]
-		if err := e.processAPIResponse(ZlmAPIEndpointVersion, body, &apiResponse); err != nil {
-			return err
+	for attempt := 0; ; attempt++ {
+		res, err := config.client.Do(req)
+		if err == nil && res.StatusCode >= http.StatusInternalServerError {
+			res.Body.Close()
+			err = fmt.Errorf("unexpected HTTP status from %s: %s", req.URL.Path, res.Status)
+			res = nil
 		}
-		data := apiResponse.Data
-		ch <- prometheus.MustNewConstMetric(ZLMediaKitInfo, prometheus.GaugeValue, 1, data.BranchName, data.BuildTime, data.CommitHash)
-		return nil
+		if err == nil || attempt >= config.options.Retries || ctx.Err() != nil {
+			breaker.record(err)
+			return res, err
+		}
+
+		wait := retryBackoff(config.options.RetryBackoff, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			breaker.record(err)
+			return nil, err
+		}
+		e.log.Debug("retrying request to ZLMediaKit", "attempt", attempt+1, "err", err)
+
+		select {
+		case <-ctx.Done():
+			breaker.record(err)
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// maxRetryBackoff caps the wait before a retry, a longer wait would outlast any scrape.
+const maxRetryBackoff = time.Minute
+
+// retryBackoff returns the wait before a retry, doubling base on every attempt up to
+// maxRetryBackoff, with a random jitter of up to half of the wait.
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	// Bounding both the base and the shift keeps the wait from overflowing.
+	wait := min(min(base, maxRetryBackoff)<<min(attempt, 16), maxRetryBackoff)
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// fetchAPI fetches an endpoint of the ZLMediaKit API and returns its decoded data.
+// Within a fetch, the collectors requesting the same endpoint share its response.
+func fetchAPI[T ZLMAPIResponseData](ctx context.Context, e *Exporter, endpoint string) (T, error) {
+	cache, ok := ctx.Value(apiCacheKey{}).(*apiCache)
+	if !ok {
+		return fetchAPIQuery[T](ctx, e, endpoint, nil)
+	}
+
+	entry, fetching := cache.entry(endpoint)
+	if fetching {
+		entry.data, entry.err = fetchAPIQuery[T](ctx, e, endpoint, nil)
+		close(entry.done)
+	}
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+		var data T
+		return data, ctx.Err()
 	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointVersion, processFunc)
+	data, ok := entry.data.(T)
+	if !ok {
+		return fetchAPIQuery[T](ctx, e, endpoint, nil)
+	}
+	return data, entry.err
 }
 
-func (e *Exporter) extractAPIStatus(ctx context.Context, ch chan<- prometheus.Metric) {
+type apiCacheKey struct{}
+
+// apiCache holds the responses of the ZLMediaKit API fetched during a fetch,
+// so that endpoints needed by several collectors such as getMediaList and
+// getApiList are requested once per scrape.
+type apiCache struct {
+	mutex   sync.Mutex
+	entries map[string]*apiCacheEntry
+}
+
+type apiCacheEntry struct {
+	done chan struct{}
+	data any
+	err  error
+}
+
+func withAPICache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, apiCacheKey{}, &apiCache{entries: make(map[string]*apiCacheEntry)})
+}
+
+// entry returns the entry of an endpoint, and whether the caller created it
+// and must fetch the endpoint and close done.
+func (c *apiCache) entry(endpoint string) (*apiCacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.entries[endpoint]; ok {
+		return entry, false
+	}
+	entry := &apiCacheEntry{done: make(chan struct{})}
+	c.entries[endpoint] = entry
+	return entry, true
+}
+
+// fetchAPIQuery fetches an endpoint of the ZLMediaKit API with query parameters and returns its decoded data.
+func fetchAPIQuery[T ZLMAPIResponseData](ctx context.Context, e *Exporter, endpoint string, query url.Values) (T, error) {
+	var apiResponse ZLMAPIResponse[T]
 	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[[]string]
+		return e.processAPIResponse(endpoint, body, &apiResponse)
+	}
+	err := e.fetchHTTP(ctx, endpoint, query, processFunc)
+	return apiResponse.Data, err
+}
 
-		if err := e.processAPIResponse(ZlmAPIEndpointGetApiList, body, &apiResponse); err != nil {
-			return err
-		}
+type APIVersionObj struct {
+	BranchName string `json:"branchName"`
+	BuildTime  string `json:"buildTime"`
+	CommitHash string `json:"commitHash"`
+}
 
-		data := apiResponse.Data
+func (e *Exporter) emitVersion(data APIVersionObj, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(ZLMediaKitInfo, prometheus.GaugeValue, 1, data.BranchName, data.BuildTime, data.CommitHash)
+}
 
-		for _, endpoint := range data {
-			ch <- prometheus.MustNewConstMetric(ApiStatus, prometheus.GaugeValue, 1, endpoint)
-		}
-		return nil
+func (e *Exporter) emitAPIStatus(data []string, ch chan<- prometheus.Metric) {
+	for _, endpoint := range data {
+		ch <- prometheus.MustNewConstMetric(ApiStatus, prometheus.GaugeValue, 1, endpoint)
 	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointGetApiList, processFunc)
 }
 
 type APINetworkThreadsObj struct {
@@ -428,25 +927,18 @@ type APINetworkThreadsObj struct {
 
 type APINetworkThreadsObjs []APINetworkThreadsObj
 
-func (e *Exporter) extractNetworkThreads(ctx context.Context, ch chan<- prometheus.Metric) {
-	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[APINetworkThreadsObjs]
-		if err := e.processAPIResponse(ZlmAPIEndpointGetNetworkThreads, body, &apiResponse); err != nil {
-			return err
-		}
-
-		var loadTotal, delayTotal, total float64
-		for _, data := range apiResponse.Data {
-			loadTotal += data.Load
-			delayTotal += data.Delay
-			total++
-		}
-		ch <- prometheus.MustNewConstMetric(NetworkThreadsTotal, prometheus.GaugeValue, total)
-		ch <- prometheus.MustNewConstMetric(NetworkThreadsLoadTotal, prometheus.GaugeValue, loadTotal)
-		ch <- prometheus.MustNewConstMetric(NetworkThreadsDelayTotal, prometheus.GaugeValue, delayTotal)
-		return nil
-	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointGetNetworkThreads, processFunc)
+func (e *Exporter) emitNetworkThreads(threads APINetworkThreadsObjs, ch chan<- prometheus.Metric) {
+	emitThreads(threads, threadsMetrics{
+		total:      NetworkThreadsTotal,
+		loadTotal:  NetworkThreadsLoadTotal,
+		delayTotal: NetworkThreadsDelayTotal,
+		loadMax:    NetworkThreadsLoadMax,
+		loadAvg:    NetworkThreadsLoadAvg,
+		delayMax:   NetworkThreadsDelayMax,
+		delayAvg:   NetworkThreadsDelayAvg,
+		load:       NetworkThreadsLoad,
+		delay:      NetworkThreadsDelay,
+	}, ch)
 }
 
 type APIWorkThreadsObj struct {
@@ -456,71 +948,97 @@ type APIWorkThreadsObj struct {
 
 type APIWorkThreadsObjs []APIWorkThreadsObj
 
-func (e *Exporter) extractWorkThreads(ctx context.Context, ch chan<- prometheus.Metric) {
-	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[APIWorkThreadsObjs]
-		if err := e.processAPIResponse(ZlmAPIEndpointGetWorkThreads, body, &apiResponse); err != nil {
-			return err
-		}
-		var loadTotal, delayTotal, total float64
-		for _, data := range apiResponse.Data {
-			loadTotal += data.Load
-			delayTotal += data.Delay
-			total++
-		}
-		ch <- prometheus.MustNewConstMetric(WorkThreadsTotal, prometheus.GaugeValue, total)
-		ch <- prometheus.MustNewConstMetric(WorkThreadsLoadTotal, prometheus.GaugeValue, loadTotal)
-		ch <- prometheus.MustNewConstMetric(WorkThreadsDelayTotal, prometheus.GaugeValue, delayTotal)
-		return nil
+func (e *Exporter) emitWorkThreads(threads APIWorkThreadsObjs, ch chan<- prometheus.Metric) {
+	emitThreads(threads, threadsMetrics{
+		total:      WorkThreadsTotal,
+		loadTotal:  WorkThreadsLoadTotal,
+		delayTotal: WorkThreadsDelayTotal,
+		loadMax:    WorkThreadsLoadMax,
+		loadAvg:    WorkThreadsLoadAvg,
+		delayMax:   WorkThreadsDelayMax,
+		delayAvg:   WorkThreadsDelayAvg,
+		load:       WorkThreadsLoad,
+		delay:      WorkThreadsDelay,
+	}, ch)
+}
+
+// perThreadMetrics enables the load and delay metrics of every thread, their
+// cardinality grows with the number of CPUs of the ZLMediaKit host.
+var perThreadMetrics = false
+
+// threadsMetrics holds the metric descriptors of a thread pool.
+type threadsMetrics struct {
+	total, loadTotal, delayTotal *prometheus.Desc
+	loadMax, loadAvg             *prometheus.Desc
+	delayMax, delayAvg           *prometheus.Desc
+	load, delay                  *prometheus.Desc
+}
+
+// emitThreads exports the sums of a thread pool along with the max and average
+// load and delay, so that a single busy event loop stands out among idle ones.
+func emitThreads[T APINetworkThreadsObj | APIWorkThreadsObj](threads []T, m threadsMetrics, ch chan<- prometheus.Metric) {
+	var loadTotal, delayTotal, loadMax, delayMax, total float64
+	for i, data := range threads {
+		thread := APINetworkThreadsObj(data)
+		loadTotal += thread.Load
+		delayTotal += thread.Delay
+		loadMax = max(loadMax, thread.Load)
+		delayMax = max(delayMax, thread.Delay)
+		total++
+		if perThreadMetrics {
+			ch <- prometheus.MustNewConstMetric(m.load, prometheus.GaugeValue, thread.Load, strconv.Itoa(i))
+			ch <- prometheus.MustNewConstMetric(m.delay, prometheus.GaugeValue, thread.Delay, strconv.Itoa(i))
+		}
 	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointGetWorkThreads, processFunc)
-}
-
-type APIStatisticsObj struct {
-	Buffer                float64 `json:"Buffer"`
-	BufferLikeString      float64 `json:"BufferLikeString"`
-	BufferList            float64 `json:"BufferList"`
-	BufferRaw             float64 `json:"BufferRaw"`
-	Frame                 float64 `json:"Frame"`
-	FrameImp              float64 `json:"FrameImp"`
-	MediaSource           float64 `json:"MediaSource"`
-	MultiMediaSourceMuxer float64 `json:"MultiMediaSourceMuxer"`
-	RtmpPacket            float64 `json:"RtmpPacket"`
-	RtpPacket             float64 `json:"RtpPacket"`
-	Socket                float64 `json:"Socket"`
-	TcpClient             float64 `json:"TcpClient"`
-	TcpServer             float64 `json:"TcpServer"`
-	TcpSession            float64 `json:"TcpSession"`
-	UdpServer             float64 `json:"UdpServer"`
-	UdpSession            float64 `json:"UdpSession"`
-}
-
-func (e *Exporter) extractStatistics(ctx context.Context, ch chan<- prometheus.Metric) {
-	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[APIStatisticsObj]
-		if err := e.processAPIResponse(ZlmAPIEndpointGetStatistics, body, &apiResponse); err != nil {
-			return err
-		}
-		data := apiResponse.Data
-		ch <- e.mustNewConstMetric(StatisticsBuffer, prometheus.GaugeValue, data.Buffer)
-		ch <- e.mustNewConstMetric(StatisticsBufferLikeString, prometheus.GaugeValue, data.BufferLikeString)
-		ch <- e.mustNewConstMetric(StatisticsBufferList, prometheus.GaugeValue, data.BufferList)
-		ch <- e.mustNewConstMetric(StatisticsBufferRaw, prometheus.GaugeValue, data.BufferRaw)
-		ch <- e.mustNewConstMetric(StatisticsFrame, prometheus.GaugeValue, data.Frame)
-		ch <- e.mustNewConstMetric(StatisticsFrameImp, prometheus.GaugeValue, data.FrameImp)
-		ch <- e.mustNewConstMetric(StatisticsMediaSource, prometheus.GaugeValue, data.MediaSource)
-		ch <- e.mustNewConstMetric(StatisticsMultiMediaSourceMuxer, prometheus.GaugeValue, data.MultiMediaSourceMuxer)
-		ch <- e.mustNewConstMetric(StatisticsRtmpPacket, prometheus.GaugeValue, data.RtmpPacket)
-		ch <- e.mustNewConstMetric(StatisticsRtpPacket, prometheus.GaugeValue, data.RtpPacket)
-		ch <- e.mustNewConstMetric(StatisticsSocket, prometheus.GaugeValue, data.Socket)
-		ch <- e.mustNewConstMetric(StatisticsTcpClient, prometheus.GaugeValue, data.TcpClient)
-		ch <- e.mustNewConstMetric(StatisticsTcpServer, prometheus.GaugeValue, data.TcpServer)
-		ch <- e.mustNewConstMetric(StatisticsTcpSession, prometheus.GaugeValue, data.TcpSession)
-		ch <- e.mustNewConstMetric(StatisticsUdpServer, prometheus.GaugeValue, data.UdpServer)
-		ch <- e.mustNewConstMetric(StatisticsUdpSession, prometheus.GaugeValue, data.UdpSession)
-		return nil
+	ch <- prometheus.MustNewConstMetric(m.total, prometheus.GaugeValue, total)
+	ch <- prometheus.MustNewConstMetric(m.loadTotal, prometheus.GaugeValue, loadTotal)
+	ch <- prometheus.MustNewConstMetric(m.delayTotal, prometheus.GaugeValue, delayTotal)
+	if total == 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(m.loadMax, prometheus.GaugeValue, loadMax)
+	ch <- prometheus.MustNewConstMetric(m.loadAvg, prometheus.GaugeValue, loadTotal/total)
+	ch <- prometheus.MustNewConstMetric(m.delayMax, prometheus.GaugeValue, delayMax)
+	ch <- prometheus.MustNewConstMetric(m.delayAvg, prometheus.GaugeValue, delayTotal/total)
+}
+
+// APIStatisticsObj holds the number of instances of the internal object types
+// of ZLMediaKit, the types depend on the version of ZLMediaKit.
+type APIStatisticsObj map[string]any
+
+// statisticsMetrics maps the object types known when the statistics collector
+// was written to their dedicated metrics, kept for compatibility.
+var statisticsMetrics = map[string]*prometheus.Desc{
+	"Buffer":                StatisticsBuffer,
+	"BufferLikeString":      StatisticsBufferLikeString,
+	"BufferList":            StatisticsBufferList,
+	"BufferRaw":             StatisticsBufferRaw,
+	"Frame":                 StatisticsFrame,
+	"FrameImp":              StatisticsFrameImp,
+	"MediaSource":           StatisticsMediaSource,
+	"MultiMediaSourceMuxer": StatisticsMultiMediaSourceMuxer,
+	"RtmpPacket":            StatisticsRtmpPacket,
+	"RtpPacket":             StatisticsRtpPacket,
+	"Socket":                StatisticsSocket,
+	"TcpClient":             StatisticsTcpClient,
+	"TcpServer":             StatisticsTcpServer,
+	"TcpSession":            StatisticsTcpSession,
+	"UdpServer":             StatisticsUdpServer,
+	"UdpSession":            StatisticsUdpSession,
+}
+
+func (e *Exporter) emitStatistics(data APIStatisticsObj, ch chan<- prometheus.Metric) {
+	for objectType, value := range data {
+		if count, ok := value.(float64); ok {
+			ch <- prometheus.MustNewConstMetric(StatisticsObjects, prometheus.GaugeValue, count, objectType)
+		} else {
+			e.log.Debug("skipping non-numeric statistics", "type", objectType, "value", value)
+		}
+	}
+	for objectType, desc := range statisticsMetrics {
+		count, _ := data[objectType].(float64)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, count)
 	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointGetStatistics, processFunc)
 }
 
 type APISessionObj struct {
@@ -535,26 +1053,61 @@ type APISessionObj struct {
 
 type APISessionObjs []APISessionObj
 
-func (e *Exporter) extractSession(ctx context.Context, ch chan<- prometheus.Metric) {
-	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[APISessionObjs]
-		if err := e.processAPIResponse(ZlmAPIEndpointGetAllSession, body, &apiResponse); err != nil {
-			return err
-		}
-		for _, v := range apiResponse.Data {
-			id := v.Id
-			identifier := v.Identifier
-			localIP := v.LocalIp
-			localPort := strconv.Itoa(v.LocalPort)
-			peerIP := v.PeerIp
-			peerPort := strconv.Itoa(v.PeerPort)
-			typeID := v.TypeID
+// sessionInfo enables zlm_session_info, which has one series per connection.
+var sessionInfo = true
+
+// sessionTopPeers caps the number of peers exported by zlm_sessions_by_peer,
+// 0 disables the metric.
+var sessionTopPeers = 0
+
+type sessionKey struct {
+	typeID    string
+	localPort string
+}
+
+func (e *Exporter) emitSession(sessions APISessionObjs, ch chan<- prometheus.Metric) {
+	counts := make(map[sessionKey]float64)
+	peers := make(map[string]float64)
+	for _, v := range sessions {
+		id := v.Id
+		identifier := v.Identifier
+		localIP := v.LocalIp
+		localPort := strconv.Itoa(v.LocalPort)
+		peerIP := v.PeerIp
+		peerPort := strconv.Itoa(v.PeerPort)
+		typeID := v.TypeID
+		if sessionInfo {
 			ch <- prometheus.MustNewConstMetric(SessionInfo, prometheus.GaugeValue, 1, id, identifier, localIP, localPort, peerIP, peerPort, typeID)
 		}
-		ch <- prometheus.MustNewConstMetric(SessionTotal, prometheus.GaugeValue, float64(len(apiResponse.Data)))
+		counts[sessionKey{typeID: typeID, localPort: localPort}]++
+		peers[peerIP]++
+	}
+	ch <- prometheus.MustNewConstMetric(SessionTotal, prometheus.GaugeValue, float64(len(sessions)))
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(Sessions, prometheus.GaugeValue, count, key.typeID, key.localPort)
+	}
+	for _, peerIP := range topPeers(peers, sessionTopPeers) {
+		ch <- prometheus.MustNewConstMetric(SessionsByPeer, prometheus.GaugeValue, peers[peerIP], peerIP)
+	}
+}
+
+// topPeers returns the n peers with the most sessions, ties are broken by the
+// peer address so that the exported peers do not flap between scrapes.
+func topPeers(peers map[string]float64, n int) []string {
+	if n <= 0 {
 		return nil
 	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointGetAllSession, processFunc)
+	ips := make([]string, 0, len(peers))
+	for ip := range peers {
+		ips = append(ips, ip)
+	}
+	slices.SortFunc(ips, func(a, b string) int {
+		if c := cmp.Compare(peers[b], peers[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	return ips[:min(n, len(ips))]
 }
 
 type APIStreamInfoObj struct {
@@ -570,80 +1123,169 @@ type APIStreamInfoObj struct {
 	Stream           string  `json:"stream"`
 	TotalReaderCount int     `json:"totalReaderCount"`
 	Vhost            string  `json:"vhost"`
+
+	// TotalBytes is only reported by recent versions of ZLMediaKit.
+	TotalBytes *float64 `json:"totalBytes"`
+
+	IsRecordingHLS bool `json:"isRecordingHLS"`
+	IsRecordingMP4 bool `json:"isRecordingMP4"`
+
+	OriginSock *APIStreamOriginSockObj `json:"originSock"`
+	Tracks     []APIStreamTrackObj     `json:"tracks"`
+}
+
+type APIStreamOriginSockObj struct {
+	Identifier string `json:"identifier"`
+	LocalIp    string `json:"local_ip"`
+	LocalPort  int    `json:"local_port"`
+	PeerIp     string `json:"peer_ip"`
+	PeerPort   int    `json:"peer_port"`
 }
 
 type APIStreamInfoObjs []APIStreamInfoObj
 
+type APIStreamTrackObj struct {
+	CodecIDName   string  `json:"codec_id_name"`
+	CodecType     int     `json:"codec_type"`
+	Frames        float64 `json:"frames"`
+	Fps           float64 `json:"fps"`
+	Width         float64 `json:"width"`
+	Height        float64 `json:"height"`
+	GopSize       float64 `json:"gop_size"`
+	GopIntervalMs float64 `json:"gop_interval_ms"`
+	KeyFrames     float64 `json:"key_frames"`
+	SampleRate    float64 `json:"sample_rate"`
+	Channels      float64 `json:"channels"`
+}
+
+// ZLMediaKit track types
+const (
+	TrackVideo = 0
+	TrackAudio = 1
+)
+
+func trackTypeName(codecType int) string {
+	switch codecType {
+	case TrackVideo:
+		return "video"
+	case TrackAudio:
+		return "audio"
+	default:
+		return strconv.Itoa(codecType)
+	}
+}
+
+// fetchStreams fetches the media list and accumulates the traffic of the streams,
+// at fetch time so that the polled snapshots are integrated only once.
+func fetchStreams(ctx context.Context, e *Exporter) (APIStreamInfoObjs, error) {
+	streams, err := fetchAPI[APIStreamInfoObjs](ctx, e, ZlmAPIEndpointGetMediaList)
+	if err != nil {
+		return nil, err
+	}
+	e.traffic.update(streams, time.Now())
+	return streams, nil
+}
+
 // Streams with the same stream name represent the same source stream,
 // while schema indicates the specific protocol.
 // ZLMediaKit automatically pushes the source stream to multiple protocols (schemas) by default.
-func (e *Exporter) extractStream(ctx context.Context, ch chan<- prometheus.Metric) {
-	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[APIStreamInfoObjs]
-		if err := e.processAPIResponse(ZlmAPIEndpointGetMediaList, body, &apiResponse); err != nil {
-			return err
-		}
+func (e *Exporter) emitStream(streams APIStreamInfoObjs, ch chan<- prometheus.Metric) {
+	uniqueStreamKeys := make(map[string]bool)
+	for _, stream := range streams {
+		streamKey := fmt.Sprintf("%s_%s_%s", stream.Vhost, stream.App, stream.Stream)
 
-		uniqueStreamKeys := make(map[string]bool)
-		for _, stream := range apiResponse.Data {
-			streamKey := fmt.Sprintf("%s_%s_%s", stream.Vhost, stream.App, stream.Stream)
+		if !uniqueStreamKeys[streamKey] {
+			ch <- prometheus.MustNewConstMetric(StreamTotalReaderCount,
+				prometheus.GaugeValue,
+				float64(stream.TotalReaderCount),
+				stream.App, stream.Stream, stream.Vhost)
+
+			ch <- prometheus.MustNewConstMetric(StreamRecording, prometheus.GaugeValue, boolToFloat64(stream.IsRecordingHLS),
+				stream.Vhost, stream.App, stream.Stream, "hls")
+			ch <- prometheus.MustNewConstMetric(StreamRecording, prometheus.GaugeValue, boolToFloat64(stream.IsRecordingMP4),
+				stream.Vhost, stream.App, stream.Stream, "mp4")
+
+			if sock := stream.OriginSock; sock != nil && sock.Identifier != "" {
+				ch <- prometheus.MustNewConstMetric(StreamOriginInfo, prometheus.GaugeValue, 1,
+					stream.Vhost, stream.App, stream.Stream,
+					sock.Identifier, sock.LocalIp, strconv.Itoa(sock.LocalPort), sock.PeerIp, strconv.Itoa(sock.PeerPort))
+			}
 
-			if !uniqueStreamKeys[streamKey] {
-				ch <- prometheus.MustNewConstMetric(StreamTotalReaderCount,
-					prometheus.GaugeValue,
-					float64(stream.TotalReaderCount),
-					stream.App, stream.Stream, stream.Vhost)
+			e.emitStreamTracks(stream, ch)
+			uniqueStreamKeys[streamKey] = true
+		}
 
-				uniqueStreamKeys[streamKey] = true
-			}
+		// stream info
+		ch <- prometheus.MustNewConstMetric(StreamsInfo, prometheus.GaugeValue,
+			1, stream.Vhost, stream.App, stream.Stream, stream.Schema,
+			stream.OriginTypeStr, stream.OriginUrl)
 
-			// stream info
-			ch <- prometheus.MustNewConstMetric(StreamsInfo, prometheus.GaugeValue,
-				1, stream.Vhost, stream.App, stream.Stream, stream.Schema,
-				stream.OriginTypeStr, stream.OriginUrl)
+		// stream status
+		status := 0.0
+		if stream.BytesSpeed > 0 {
+			status = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(StreamStatus, prometheus.GaugeValue,
+			status, stream.Vhost, stream.App, stream.Stream, stream.Schema)
 
-			// stream status
-			status := 0.0
-			if stream.BytesSpeed > 0 {
-				status = 1.0
-			}
-			ch <- prometheus.MustNewConstMetric(StreamStatus, prometheus.GaugeValue,
-				status, stream.Vhost, stream.App, stream.Stream, stream.Schema)
+		// stream reader count
+		ch <- prometheus.MustNewConstMetric(StreamReaderCount,
+			prometheus.GaugeValue,
+			float64(stream.ReaderCount),
+			stream.Vhost, stream.App, stream.Stream, stream.Schema)
 
-			// stream reader count
-			ch <- prometheus.MustNewConstMetric(StreamReaderCount,
-				prometheus.GaugeValue,
-				float64(stream.ReaderCount),
-				stream.Vhost, stream.App, stream.Stream, stream.Schema)
+		// stream bitrate
+		ch <- prometheus.MustNewConstMetric(StreamBitrate,
+			prometheus.GaugeValue,
+			stream.BytesSpeed,
+			stream.Vhost, stream.App, stream.Stream, stream.Schema)
 
-			// stream bitrate
-			ch <- prometheus.MustNewConstMetric(StreamBitrate,
-				prometheus.GaugeValue,
-				stream.BytesSpeed,
-				stream.Vhost, stream.App, stream.Stream, stream.Schema)
+		// stream alive second
+		ch <- prometheus.MustNewConstMetric(StreamaliveSecond,
+			prometheus.GaugeValue,
+			float64(stream.AliveSecond),
+			stream.Vhost, stream.App, stream.Stream, stream.Schema)
 
-			// stream alive second
-			ch <- prometheus.MustNewConstMetric(StreamaliveSecond,
-				prometheus.GaugeValue,
-				float64(stream.AliveSecond),
+		// stream create stamp
+		ch <- prometheus.MustNewConstMetric(StreamCreateStamp,
+			prometheus.GaugeValue,
+			float64(stream.CreateStamp),
+			stream.Vhost, stream.App, stream.Stream, stream.Schema)
+
+		// stream bytes
+		if !e.probe {
+			ch <- prometheus.MustNewConstMetric(StreamBytesTotal,
+				prometheus.CounterValue,
+				e.traffic.bytes(stream.Vhost, stream.App, stream.Stream, stream.Schema),
 				stream.Vhost, stream.App, stream.Stream, stream.Schema)
+		}
+	}
 
-			// stream create stamp
-			ch <- prometheus.MustNewConstMetric(StreamCreateStamp,
-				prometheus.GaugeValue,
-				float64(stream.CreateStamp),
-				stream.Vhost, stream.App, stream.Stream, stream.Schema)
+	// stream total
+	ch <- prometheus.MustNewConstMetric(StreamTotal,
+		prometheus.GaugeValue,
+		float64(len(uniqueStreamKeys)))
+}
 
-			// todo: 增加一个zlm_stream_bytes 字段，表示流的总流量
+// emitStreamTracks writes the metrics of the tracks of a stream, once for all its schemas.
+func (e *Exporter) emitStreamTracks(stream APIStreamInfoObj, ch chan<- prometheus.Metric) {
+	for _, track := range stream.Tracks {
+		labels := []string{stream.Vhost, stream.App, stream.Stream, trackTypeName(track.CodecType), track.CodecIDName}
+		ch <- prometheus.MustNewConstMetric(StreamTrackFrames, prometheus.CounterValue, track.Frames, labels...)
+
+		switch track.CodecType {
+		case TrackVideo:
+			ch <- prometheus.MustNewConstMetric(StreamTrackFPS, prometheus.GaugeValue, track.Fps, labels...)
+			ch <- prometheus.MustNewConstMetric(StreamTrackWidth, prometheus.GaugeValue, track.Width, labels...)
+			ch <- prometheus.MustNewConstMetric(StreamTrackHeight, prometheus.GaugeValue, track.Height, labels...)
+			ch <- prometheus.MustNewConstMetric(StreamTrackGopSize, prometheus.GaugeValue, track.GopSize, labels...)
+			ch <- prometheus.MustNewConstMetric(StreamTrackGopInterval, prometheus.GaugeValue, track.GopIntervalMs/1000, labels...)
+			ch <- prometheus.MustNewConstMetric(StreamTrackKeyFrames, prometheus.CounterValue, track.KeyFrames, labels...)
+		case TrackAudio:
+			ch <- prometheus.MustNewConstMetric(StreamTrackSampleRate, prometheus.GaugeValue, track.SampleRate, labels...)
+			ch <- prometheus.MustNewConstMetric(StreamTrackChannels, prometheus.GaugeValue, track.Channels, labels...)
 		}
-
-		// stream total
-		ch <- prometheus.MustNewConstMetric(StreamTotal,
-			prometheus.GaugeValue,
-			float64(len(uniqueStreamKeys)))
-		return nil
 	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointGetMediaList, processFunc)
 }
 
 type APIRtpServerObj struct {
@@ -653,21 +1295,334 @@ type APIRtpServerObj struct {
 
 type APIRtpServerObjs []APIRtpServerObj
 
-func (e *Exporter) extractRtp(ctx context.Context, ch chan<- prometheus.Metric) {
-	processFunc := func(body io.ReadCloser) error {
-		var apiResponse ZLMAPIResponse[APIRtpServerObjs]
-		if err := e.processAPIResponse(ZlmAPIEndpointListRtpServer, body, &apiResponse); err != nil {
-			return err
+func (e *Exporter) emitRtp(servers APIRtpServerObjs, ch chan<- prometheus.Metric) {
+	for _, v := range servers {
+		rtpPort := v.Port
+		streamID := v.StreamID
+		ch <- prometheus.MustNewConstMetric(RtpServerInfo, prometheus.GaugeValue, 1, rtpPort, streamID)
+	}
+	ch <- prometheus.MustNewConstMetric(RtpServerTotal, prometheus.GaugeValue, float64(len(servers)))
+}
+
+type APIMp4RecordFileObj struct {
+	Paths    []string `json:"paths"`
+	RootPath string   `json:"rootPath"`
+}
+
+type streamRecordFiles struct {
+	Vhost  string
+	App    string
+	Stream string
+	Files  int
+}
+
+// fetchRecordFiles counts the MP4 files recorded today by every stream when getApiList
+// advertises getMP4RecordFile, which takes one request per stream. ZLMediaKit names the
+// folders of the recordings after its local date, expected to match the one of the exporter.
+// The streams whose request fails are left out, the failure is counted by scrape_errors_total.
+func fetchRecordFiles(ctx context.Context, e *Exporter) ([]streamRecordFiles, error) {
+	endpoints, err := fetchAPI[[]string](ctx, e, ZlmAPIEndpointGetApiList)
+	if err != nil {
+		return nil, err
+	}
+	if !apiAvailable(endpoints, ZlmAPIEndpointGetMp4RecordFile) {
+		return nil, nil
+	}
+
+	streams, err := fetchAPI[APIStreamInfoObjs](ctx, e, ZlmAPIEndpointGetMediaList)
+	if err != nil {
+		return nil, err
+	}
+
+	period := time.Now().Format(time.DateOnly)
+	uniqueStreamKeys := make(map[string]bool)
+	var records []streamRecordFiles
+	for _, stream := range streams {
+		streamKey := fmt.Sprintf("%s_%s_%s", stream.Vhost, stream.App, stream.Stream)
+		if uniqueStreamKeys[streamKey] {
+			continue
 		}
-		for _, v := range apiResponse.Data {
-			rtpPort := v.Port
-			streamID := v.StreamID
-			ch <- prometheus.MustNewConstMetric(RtpServerInfo, prometheus.GaugeValue, 1, rtpPort, streamID)
+		uniqueStreamKeys[streamKey] = true
+
+		query := url.Values{
+			"vhost":  {stream.Vhost},
+			"app":    {stream.App},
+			"stream": {stream.Stream},
+			"period": {period},
 		}
-		ch <- prometheus.MustNewConstMetric(RtpServerTotal, prometheus.GaugeValue, float64(len(apiResponse.Data)))
-		return nil
+		files, err := fetchAPIQuery[APIMp4RecordFileObj](ctx, e, ZlmAPIEndpointGetMp4RecordFile, query)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			e.log.Debug("skipping the record files of a stream", "vhost", stream.Vhost, "app", stream.App, "stream", stream.Stream, "err", err)
+			continue
+		}
+		records = append(records, streamRecordFiles{
+			Vhost:  stream.Vhost,
+			App:    stream.App,
+			Stream: stream.Stream,
+			Files:  len(files.Paths),
+		})
+	}
+	return records, nil
+}
+
+func (e *Exporter) emitRecordFiles(records []streamRecordFiles, ch chan<- prometheus.Metric) {
+	for _, record := range records {
+		ch <- prometheus.MustNewConstMetric(RecordMP4Files, prometheus.GaugeValue, float64(record.Files), record.Vhost, record.App, record.Stream)
+	}
+}
+
+// APIServerConfigObjs holds the settings of the server, ZLMediaKit returns them as strings.
+type APIServerConfigObjs []map[string]string
+
+// configSettings lists the settings exported by the config collector.
+var configSettings = strings.Join([]string{
+	"http.port", "http.sslport", "rtmp.port", "rtmp.sslport", "rtsp.port", "rtsp.sslport",
+	"rtc.port", "rtc.tcpPort", "srt.port", "shell.port", "rtp_proxy.port", "rtp_proxy.port_range",
+	"hls.segDur", "hls.segNum", "hls.segRetain",
+	"general.streamNoneReaderDelayMS", "general.maxStreamWaitMS",
+	"protocol.enable_audio", "protocol.enable_hls", "protocol.enable_hls_fmp4", "protocol.enable_mp4",
+	"protocol.enable_rtmp", "protocol.enable_rtsp", "protocol.enable_ts", "protocol.enable_fmp4",
+	"hook.enable", "hook.alive_interval", "ffmpeg.restart_sec",
+}, ",")
+
+// configSettingKeys holds the settings of configSettings, parsed once the flags are.
+var configSettingKeys = parseConfigSettings(configSettings)
+
+// parseConfigSettings splits a comma separated list of settings, dropping the empty
+// and repeated ones which would export the same series twice.
+func parseConfigSettings(settings string) []string {
+	var keys []string
+	for _, key := range strings.Split(settings, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (e *Exporter) emitServerConfig(configs APIServerConfigObjs, ch chan<- prometheus.Metric) {
+	if len(configs) == 0 {
+		return
+	}
+	config := configs[0]
+	ch <- prometheus.MustNewConstMetric(ConfigInfo, prometheus.GaugeValue, 1, config["general.mediaServerId"])
+
+	for _, key := range configSettingKeys {
+		value, ok := config[key]
+		// The allow-list cannot expose api.secret, nor any other secret.
+		if !ok || strings.Contains(strings.ToLower(key), "secret") {
+			continue
+		}
+
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			ch <- prometheus.MustNewConstMetric(ConfigSetting, prometheus.GaugeValue, v, key)
+			continue
+		}
+		// Port ranges such as rtp_proxy.port_range are exported as their bounds.
+		if low, high, ok := strings.Cut(value, "-"); ok {
+			first, errFirst := strconv.ParseFloat(low, 64)
+			last, errLast := strconv.ParseFloat(high, 64)
+			if errFirst == nil && errLast == nil {
+				ch <- prometheus.MustNewConstMetric(ConfigSetting, prometheus.GaugeValue, first, key+"_min")
+				ch <- prometheus.MustNewConstMetric(ConfigSetting, prometheus.GaugeValue, last, key+"_max")
+				continue
+			}
+		}
+		e.log.Debug("skipping non numeric setting", "key", key)
 	}
-	e.fetchHTTP(ctx, ch, ZlmAPIEndpointListRtpServer, processFunc)
+}
+
+type APIStreamProxyObj struct {
+	Key            string  `json:"key"`
+	URL            string  `json:"url"`
+	Status         int     `json:"status"`
+	LiveSecs       float64 `json:"liveSecs"`
+	RePullCount    int     `json:"rePullCount"`
+	RePublishCount int     `json:"rePublishCount"`
+
+	Src APIMediaTupleObj `json:"src"`
+}
+
+type APIStreamProxyObjs []APIStreamProxyObj
+
+type APIMediaTupleObj struct {
+	Vhost  string `json:"vhost"`
+	App    string `json:"app"`
+	Stream string `json:"stream"`
+}
+
+// proxyEndpoints maps the type label of the proxies to the endpoint listing them,
+// both endpoints only exist in recent versions of ZLMediaKit.
+var proxyEndpoints = map[string]string{
+	"pull": ZlmAPIEndpointListStreamProxy,
+	"push": ZlmAPIEndpointListPusherProxy,
+}
+
+// fetchStreamProxies lists the proxies of every type whose endpoint is
+// advertised by getApiList, the missing types are left out of the result.
+func fetchStreamProxies(ctx context.Context, e *Exporter) (map[string]APIStreamProxyObjs, error) {
+	endpoints, err := fetchAPI[[]string](ctx, e, ZlmAPIEndpointGetApiList)
+	if err != nil {
+		return nil, err
+	}
+
+	proxies := make(map[string]APIStreamProxyObjs)
+	for proxyType, endpoint := range proxyEndpoints {
+		if !apiAvailable(endpoints, endpoint) {
+			continue
+		}
+		data, err := fetchAPI[APIStreamProxyObjs](ctx, e, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		proxies[proxyType] = data
+	}
+	return proxies, nil
+}
+
+func (e *Exporter) emitStreamProxies(proxies map[string]APIStreamProxyObjs, ch chan<- prometheus.Metric) {
+	for proxyType, data := range proxies {
+		for _, proxy := range data {
+			retries := proxy.RePullCount
+			if proxyType == "push" {
+				retries = proxy.RePublishCount
+			}
+			ch <- prometheus.MustNewConstMetric(ProxyInfo, prometheus.GaugeValue, 1,
+				proxyType, proxy.Key, proxy.Src.Vhost, proxy.Src.App, proxy.Src.Stream, sanitizeURL(proxy.URL))
+			ch <- prometheus.MustNewConstMetric(ProxyStatus, prometheus.GaugeValue, float64(proxy.Status), proxyType, proxy.Key)
+			ch <- prometheus.MustNewConstMetric(ProxyUp, prometheus.GaugeValue, boolToFloat64(proxy.Status == 0), proxyType, proxy.Key)
+			ch <- prometheus.MustNewConstMetric(ProxyRetries, prometheus.CounterValue, float64(retries), proxyType, proxy.Key)
+			ch <- prometheus.MustNewConstMetric(ProxyLiveSeconds, prometheus.GaugeValue, proxy.LiveSecs, proxyType, proxy.Key)
+		}
+		ch <- prometheus.MustNewConstMetric(ProxyTotal, prometheus.GaugeValue, float64(len(data)), proxyType)
+	}
+}
+
+// apiAvailable reports whether endpoint is listed by getApiList.
+func apiAvailable(endpoints []string, endpoint string) bool {
+	return slices.Contains(endpoints, "/"+endpoint)
+}
+
+type APIFFmpegSourceObj struct {
+	Key    string `json:"key"`
+	SrcURL string `json:"src_url"`
+	DstURL string `json:"dst_url"`
+	CmdKey string `json:"ffmpeg_cmd_key"`
+
+	Src APIMediaTupleObj `json:"src"`
+}
+
+type APIFFmpegSourceObjs []APIFFmpegSourceObj
+
+// ffmpegSource is an FFmpeg source along with the state of its destination
+// stream, which ZLMediaKit does not report itself.
+type ffmpegSource struct {
+	APIFFmpegSourceObj
+	Up       bool
+	Restarts float64
+}
+
+// fetchFFmpegSources lists the FFmpeg sources when getApiList advertises
+// listFFmpegSource and looks up their destination streams in the media list.
+func fetchFFmpegSources(ctx context.Context, e *Exporter) ([]ffmpegSource, error) {
+	endpoints, err := fetchAPI[[]string](ctx, e, ZlmAPIEndpointGetApiList)
+	if err != nil {
+		return nil, err
+	}
+	if !apiAvailable(endpoints, ZlmAPIEndpointListFFmpegSource) {
+		return nil, nil
+	}
+
+	data, err := fetchAPI[APIFFmpegSourceObjs](ctx, e, ZlmAPIEndpointListFFmpegSource)
+	if err != nil {
+		return nil, err
+	}
+	streams, err := fetchAPI[APIStreamInfoObjs](ctx, e, ZlmAPIEndpointGetMediaList)
+	if err != nil {
+		return nil, err
+	}
+	return e.ffmpegRestarts.update(data, streams), nil
+}
+
+func (e *Exporter) emitFFmpegSources(sources []ffmpegSource, ch chan<- prometheus.Metric) {
+	if sources == nil {
+		return
+	}
+	for _, source := range sources {
+		ch <- prometheus.MustNewConstMetric(FFmpegSourceInfo, prometheus.GaugeValue, 1,
+			source.Key, source.Src.Vhost, source.Src.App, source.Src.Stream,
+			sanitizeURL(source.SrcURL), sanitizeURL(source.DstURL), source.CmdKey)
+		ch <- prometheus.MustNewConstMetric(FFmpegSourceUp, prometheus.GaugeValue, boolToFloat64(source.Up), source.Key)
+		if !e.probe {
+			ch <- prometheus.MustNewConstMetric(FFmpegSourceRestarts, prometheus.CounterValue, source.Restarts, source.Key)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(FFmpegSourceTotal, prometheus.GaugeValue, float64(len(sources)))
+}
+
+// ffmpegRestarts counts the restarts of the FFmpeg sources, seen as a new
+// createStamp of their destination stream between two fetches.
+type ffmpegRestarts struct {
+	mutex   sync.Mutex
+	sources map[string]*ffmpegRestartsState
+}
+
+type ffmpegRestartsState struct {
+	createStamp int
+	restarts    float64
+}
+
+func newFFmpegRestarts() *ffmpegRestarts {
+	return &ffmpegRestarts{sources: make(map[string]*ffmpegRestartsState)}
+}
+
+func (r *ffmpegRestarts) update(data APIFFmpegSourceObjs, streams APIStreamInfoObjs) []ffmpegSource {
+	createStamps := make(map[APIMediaTupleObj]int)
+	for _, stream := range streams {
+		createStamps[APIMediaTupleObj{Vhost: stream.Vhost, App: stream.App, Stream: stream.Stream}] = stream.CreateStamp
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sources := make([]ffmpegSource, 0, len(data))
+	states := make(map[string]*ffmpegRestartsState, len(data))
+	for _, source := range data {
+		state, ok := r.sources[source.Key]
+		if !ok {
+			state = &ffmpegRestartsState{}
+		}
+		states[source.Key] = state
+
+		createStamp, up := createStamps[APIMediaTupleObj{Vhost: source.Src.Vhost, App: source.Src.App, Stream: source.Src.Stream}]
+		if up {
+			if state.createStamp != 0 && state.createStamp != createStamp {
+				state.restarts++
+			}
+			state.createStamp = createStamp
+		}
+		sources = append(sources, ffmpegSource{APIFFmpegSourceObj: source, Up: up, Restarts: state.restarts})
+	}
+	// Deleted sources are forgotten, a source added again with the same key starts over.
+	r.sources = states
+	return sources
+}
+
+// sanitizeURL strips the credentials, query and fragment of a source or
+// destination URL, which often carry passwords or tokens of the cameras and CDNs.
+func sanitizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
 }
 
 func maskSecret(secret string) string {
@@ -684,12 +1639,32 @@ var (
 	webFlagConfig = webflag.AddFlags(kingpin.CommandLine, getEnv("ZLM_EXPORTER_TELEMETRY_ADDRESS", ":9101"))
 	webTimeout    = kingpin.Flag("web.timeout", "Timeout for connection to ZlMediaKit instance (default 15s).").
 			Default(getEnv("ZLM_EXPORTER_TIMEOUT", "15s")).Duration()
-	webSSLVerify = kingpin.Flag("web.ssl-verify", "Enable SSL verification(default true).").
+	webTimeoutOffset = kingpin.Flag("web.timeout-offset", "Offset to subtract from the Prometheus scrape timeout (default 500ms).").
+				Default(getEnv("ZLM_EXPORTER_TIMEOUT_OFFSET", "500ms")).Duration()
+	webSSLVerify = kingpin.Flag("web.ssl-verify", "Verify the certificate of the ZLMediaKit API (default true).").
 			Default(getEnv("ZLM_EXPORTER_SSL_VERIFY", "true")).Bool()
 
 	metricsPath = kingpin.Flag("web.telemetry-path",
 		"Path under which to expose metrics (default /metrics)").
 		Default(getEnv("ZLM_EXPORTER_TELEMETRY_PATH", "/metrics")).String()
+	probePath = kingpin.Flag("web.probe-path",
+		"Path under which to expose the multi-target probe endpoint (default /probe)").
+		Default(getEnv("ZLM_EXPORTER_PROBE_PATH", "/probe")).String()
+	hookPath = kingpin.Flag("web.hook-path",
+		"Path under which to receive the web hooks of ZLMediaKit, such as <path>/on_publish, empty disables the receiver (default disabled).").
+		Default(getEnv("ZLM_EXPORTER_HOOK_PATH", "")).String()
+	hookClientsWindow = kingpin.Flag("web.hook-clients-window",
+		"Window within which the clients of on_publish and on_play are counted as unique clients (default 5m).").
+		Default(getEnv("ZLM_EXPORTER_HOOK_CLIENTS_WINDOW", "5m")).Duration()
+	hookUpstream = kingpin.Flag("web.hook-upstream",
+		"URL the web hooks are forwarded to, followed by their path below --web.hook-path. The answer of the upstream is relayed to ZLMediaKit, empty answers {\"code\":0} (default disabled).").
+		Default(getEnv("ZLM_EXPORTER_HOOK_UPSTREAM", "")).String()
+	hookUpstreamTimeout = kingpin.Flag("web.hook-upstream-timeout",
+		"Timeout of the requests forwarding the web hooks to the upstream (default 9s).").
+		Default(getEnv("ZLM_EXPORTER_HOOK_UPSTREAM_TIMEOUT", "9s")).Duration()
+	hookKeepaliveMultiplier = kingpin.Flag("web.hook-keepalive-multiplier",
+		"Number of hook.alive_interval without on_server_keepalive after which ZLMediaKit is reported stale (default 3).").
+		Default(getEnv("ZLM_EXPORTER_HOOK_KEEPALIVE_MULTIPLIER", "3")).Float64()
 	metricOnly = kingpin.Flag("web.metric-only",
 		"Only export metrics, not other key-value metrics(default true).").
 		Default(getEnv("ZLM_EXPORTER_METRIC_ONLY", "true")).Bool()
@@ -699,6 +1674,35 @@ var (
 		Default(getEnv("ZLM_API_URL", "http://127.0.0.1")).String()
 	zlmApiSecret = kingpin.Flag("zlm.secret", "Secret for the access ZlMediaKit api(from ZLM_API_SECRET env or CLI flag).").
 			PlaceHolder("<secret>").String()
+
+	zlmTimeout = kingpin.Flag("zlm.timeout", "Timeout of a scrape of the ZLMediaKit API, capped by the Prometheus scrape timeout (default 12s).").
+			Default(getEnv("ZLM_SCRAPE_TIMEOUT", "12s")).Duration()
+
+	zlmPollInterval = kingpin.Flag("zlm.poll-interval", "Interval to poll the ZLMediaKit API in the background and serve /metrics from the last snapshot, 0 scrapes on every request (default 0).").
+			Default(getEnv("ZLM_POLL_INTERVAL", "0s")).Duration()
+
+	zlmRetries = kingpin.Flag("zlm.retries", "Number of retries of a failed request to the ZLMediaKit API within the scrape timeout (default 2).").
+			Default(getEnv("ZLM_RETRIES", "2")).Int()
+	zlmRetryBackoff = kingpin.Flag("zlm.retry-backoff", "Initial backoff between retries, doubled on every retry with a random jitter (default 100ms).").
+			Default(getEnv("ZLM_RETRY_BACKOFF", "100ms")).Duration()
+	zlmCircuitBreakerThreshold = kingpin.Flag("zlm.circuit-breaker-threshold", "Number of consecutive failed requests opening the circuit breaker of a ZLMediaKit API, 0 disables it (default 10).").
+					Default(getEnv("ZLM_CIRCUIT_BREAKER_THRESHOLD", "10")).Int()
+	zlmCircuitBreakerCooldown = kingpin.Flag("zlm.circuit-breaker-cooldown", "Time the circuit breaker stays open before letting a request through (default 30s).").
+					Default(getEnv("ZLM_CIRCUIT_BREAKER_COOLDOWN", "30s")).Duration()
+
+	zlmTLSCAFile = kingpin.Flag("zlm.tls.ca-file", "CA bundle to verify the certificate of the ZLMediaKit API.").
+			Default(getEnv("ZLM_TLS_CA_FILE", "")).String()
+	zlmTLSCertFile = kingpin.Flag("zlm.tls.cert-file", "Client certificate presented to the ZLMediaKit API.").
+			Default(getEnv("ZLM_TLS_CERT_FILE", "")).String()
+	zlmTLSKeyFile = kingpin.Flag("zlm.tls.key-file", "Key of the client certificate presented to the ZLMediaKit API.").
+			Default(getEnv("ZLM_TLS_KEY_FILE", "")).String()
+	zlmTLSServerName = kingpin.Flag("zlm.tls.server-name", "Server name used to verify the certificate of the ZLMediaKit API.").
+				Default(getEnv("ZLM_TLS_SERVER_NAME", "")).String()
+	zlmTLSMinVersion = kingpin.Flag("zlm.tls.min-version", "Minimum TLS version accepted from the ZLMediaKit API (TLS10, TLS11, TLS12 or TLS13).").
+				Default(getEnv("ZLM_TLS_MIN_VERSION", "")).String()
+
+	configFile = kingpin.Flag("config.file", "Path to the configuration file describing probe modules and targets.").
+			Default(getEnv("ZLM_EXPORTER_CONFIG_FILE", "")).String()
 )
 
 // doc: https://prometheus.io/docs/instrumenting/writing_exporters/
@@ -707,6 +1711,7 @@ func main() {
 	kingpin.Version(version.Print("zlm_exporter"))
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
+	configSettingKeys = parseConfigSettings(configSettings)
 	promslogConfig := &promslog.Config{}
 	logger := promslog.New(promslogConfig)
 
@@ -724,30 +1729,99 @@ func main() {
 	logger.Info("Configuration")
 	logger.Info("web configuration",
 		"timeout", *webTimeout,
+		"timeout_offset", *webTimeoutOffset,
+		"zlm_timeout", *zlmTimeout,
+		"zlm_poll_interval", *zlmPollInterval,
+		"zlm_retries", *zlmRetries,
+		"zlm_retry_backoff", *zlmRetryBackoff,
+		"zlm_circuit_breaker_threshold", *zlmCircuitBreakerThreshold,
+		"zlm_circuit_breaker_cooldown", *zlmCircuitBreakerCooldown,
 		"ssl_verify", *webSSLVerify,
+		"zlm_tls_ca_file", *zlmTLSCAFile,
+		"zlm_tls_cert_file", *zlmTLSCertFile,
+		"zlm_tls_server_name", *zlmTLSServerName,
+		"zlm_tls_min_version", *zlmTLSMinVersion,
 		"zlm_api_url", *zlmApiURL,
 		"zlm_api_secret", maskSecret(*zlmApiSecret),
 		"metrics_path", *metricsPath,
+		"probe_path", *probePath,
+		"hook_path", *hookPath,
+		"hook_clients_window", *hookClientsWindow,
+		"hook_upstream", *hookUpstream,
+		"hook_upstream_timeout", *hookUpstreamTimeout,
+		"hook_keepalive_multiplier", *hookKeepaliveMultiplier,
+		"config_file", *configFile,
 		"metrics_only", *metricOnly)
 
-	option := Options{
-		SSLVerify: *webSSLVerify,
+	registry := prometheus.NewRegistry()
+	if !*metricOnly {
+		registry = prometheus.DefaultRegisterer.(*prometheus.Registry)
 	}
 
-	exporter, err := NewExporter(*zlmApiURL, *zlmApiSecret, logger, option)
-	if err != nil {
-		logger.Error("failed to create new exporter", "error", err)
+	if *zlmRetries < 0 {
+		logger.Error("--zlm.retries must not be negative")
 		os.Exit(1)
 	}
-
-	registry := prometheus.NewRegistry()
-	if !*metricOnly {
-		registry = prometheus.DefaultRegisterer.(*prometheus.Registry)
+	breakers.configure(*zlmCircuitBreakerThreshold, *zlmCircuitBreakerCooldown)
+
+	tlsConfig := promconfig.TLSConfig{
+		CAFile:             *zlmTLSCAFile,
+		CertFile:           *zlmTLSCertFile,
+		KeyFile:            *zlmTLSKeyFile,
+		ServerName:         *zlmTLSServerName,
+		InsecureSkipVerify: !*webSSLVerify,
+	}
+	if *zlmTLSMinVersion != "" {
+		minVersion, ok := promconfig.TLSVersions[*zlmTLSMinVersion]
+		if !ok {
+			logger.Error("unknown TLS version", "min_version", *zlmTLSMinVersion)
+			os.Exit(1)
+		}
+		tlsConfig.MinVersion = minVersion
 	}
-	registry.MustRegister(exporter)
-	http.Handle(*metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+
+	targets := newTargetsHandler(registry, promhttp.HandlerOpts{
 		Timeout: *webTimeout,
-	}))
+	}, *webTimeoutOffset, *zlmPollInterval, logger)
+
+	reloader := newReloader(*configFile, *zlmApiURL, Module{
+		Secret:       *zlmApiSecret,
+		Timeout:      *zlmTimeout,
+		Retries:      zlmRetries,
+		RetryBackoff: *zlmRetryBackoff,
+		TLSConfig:    tlsConfig,
+	}, targets, logger)
+	if err := reloader.reload(); err != nil {
+		logger.Error("failed to load configuration", "error", err)
+		os.Exit(1)
+	}
+	registry.MustRegister(reloader)
+	go reloader.watchSignals()
+
+	http.Handle(*metricsPath, targets)
+	http.HandleFunc(*probePath, func(w http.ResponseWriter, r *http.Request) {
+		probeHandler(w, r, reloader.currentConfig(), logger, *webTimeout, *webTimeoutOffset)
+	})
+	http.Handle("/-/reload", reloader)
+	if *hookPath != "" {
+		if err := validateHookUpstream(*hookUpstream); err != nil {
+			logger.Error("invalid --web.hook-upstream", "error", err)
+			os.Exit(1)
+		}
+		if *hookKeepaliveMultiplier <= 0 {
+			logger.Error("--web.hook-keepalive-multiplier must be positive")
+			os.Exit(1)
+		}
+		hooks := newHookReceiver(logger, hookOptions{
+			ClientsWindow:       *hookClientsWindow,
+			Upstream:            *hookUpstream,
+			UpstreamTimeout:     *hookUpstreamTimeout,
+			KeepaliveMultiplier: *hookKeepaliveMultiplier,
+		})
+		registry.MustRegister(hooks)
+		prefix := strings.TrimSuffix(*hookPath, "/")
+		http.Handle(prefix+"/", http.StripPrefix(prefix, hooks))
+	}
 	svr := &http.Server{}
 
 	logger.Info("zlm_exporter started successfully, metrics available at", "metrics_path", *metricsPath)